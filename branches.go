@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+type envBranch struct {
+	Env    string
+	Branch string
+}
+
+// allBranches maps every environment to its branch, in canonical environment
+// order
+var allBranches = []envBranch{
+	{Env: "int", Branch: "main"},
+	{Env: "stg", Branch: "release/hcp/public/stg"},
+	{Env: "prod", Branch: "release/hcp/public/prod"},
+}
+
+// applyBranchMappings applies env=branch mappings to branches. Known
+// environments get their branch replaced, unknown ones are appended.
+func applyBranchMappings(branches []envBranch, mappings []string) ([]envBranch, error) {
+	result := append([]envBranch(nil), branches...)
+
+	for _, mapping := range mappings {
+		env, branch, ok := strings.Cut(mapping, "=")
+		env = strings.TrimSpace(env)
+		branch = strings.TrimSpace(branch)
+		if !ok || env == "" || branch == "" {
+			return nil, fmt.Errorf("invalid branch mapping '%s', expected env=branch", mapping)
+		}
+		if tag, ok := tagName(branch); ok {
+			if tag == "" || isBranchPattern(tag) {
+				return nil, fmt.Errorf("invalid tag mapping '%s', expected env=tag:<name> without wildcards", mapping)
+			}
+		} else if isBranchPattern(branch) {
+			if _, err := path.Match(branch, ""); err != nil {
+				return nil, fmt.Errorf("invalid branch pattern '%s': %v", branch, err)
+			}
+		}
+
+		replaced := false
+		for i := range result {
+			if result[i].Env == env {
+				result[i].Branch = branch
+				replaced = true
+			}
+		}
+		if !replaced {
+			result = append(result, envBranch{Env: env, Branch: branch})
+		}
+	}
+
+	return result, nil
+}
+
+// excludeBranches removes the environments whose branch is one of excluded
+// from selectedEnvs. It warns about exclusions that match none of the selected
+// environments, which usually means a typo.
+func excludeBranches(selectedEnvs []string, excluded []string) []string {
+	branchOf := make(map[string]string)
+	for _, eb := range allBranches {
+		branchOf[eb.Env] = eb.Branch
+	}
+
+	remaining := selectedEnvs
+	for _, branch := range excluded {
+		var kept []string
+		for _, env := range remaining {
+			if branchOf[env] != branch {
+				kept = append(kept, env)
+			}
+		}
+		if len(kept) == len(remaining) {
+			fmt.Fprintf(os.Stderr, "Warning: --exclude-branch '%s' doesn't match any selected environment's branch\n", branch)
+		}
+		remaining = kept
+	}
+
+	return remaining
+}
+
+// discoverBranches lists the branches of remote starting with prefix in the
+// repository at directory. The remote is asked with git ls-remote unless quick
+// is set, in which case the remote-tracking branches as of the last fetch are
+// used.
+func discoverBranches(directory, remote, prefix string, quick bool) ([]string, error) {
+	originalDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chdir(directory); err != nil {
+		return nil, fmt.Errorf("failed to change to directory '%s': %w", directory, err)
+	}
+	defer os.Chdir(originalDir)
+
+	var output []byte
+	var refPrefix string
+	if quick {
+		refPrefix = "refs/remotes/" + remote + "/"
+		output, err = runGit("for-each-ref", "--format=%(refname)", refPrefix+prefix+"*")
+	} else {
+		refPrefix = "refs/heads/"
+		output, err = runGit("ls-remote", "--heads", remote, refPrefix+prefix+"*")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches of %s: %w", remote, err)
+	}
+
+	var branches []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		// ls-remote prints <hash> TAB <ref>
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		branch := strings.TrimPrefix(fields[len(fields)-1], refPrefix)
+		if strings.HasPrefix(branch, prefix) && branch != prefix {
+			branches = append(branches, branch)
+		}
+	}
+	sort.Strings(branches)
+	return branches, nil
+}
+
+// listRefs returns the branches and tags of remote as refs/heads/<branch>
+// and refs/tags/<tag>, listed with git ls-remote or, in quick mode, from the
+// remote-tracking branches and tags as of the last fetch. Bare mirror clones
+// keep the branches of the remote as local branches, so those count as well.
+func listRefs(remote string, quick bool) (map[string]bool, error) {
+	var output []byte
+	var err error
+	if quick {
+		patterns := []string{"refs/remotes/" + remote + "/", "refs/tags/"}
+		if bareRepo {
+			patterns = append(patterns, "refs/heads/")
+		}
+		output, err = runGit(append([]string{"for-each-ref", "--format=%(refname)"}, patterns...)...)
+	} else {
+		output, err = runGit("ls-remote", "--heads", "--tags", remote)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the refs of %s: %w", remote, err)
+	}
+
+	refs := make(map[string]bool)
+	remotePrefix := "refs/remotes/" + remote + "/"
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		// ls-remote prints <hash> TAB <ref>
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		ref := strings.TrimSuffix(fields[len(fields)-1], "^{}")
+		if branch, ok := strings.CutPrefix(ref, remotePrefix); ok {
+			ref = "refs/heads/" + branch
+		}
+		refs[ref] = true
+	}
+	return refs, nil
+}
+
+// branchExists tells whether the branch, tag:<name> or branch pattern of an
+// environment is among refs as returned by listRefs
+func branchExists(refs map[string]bool, branch string) bool {
+	if tag, ok := tagName(branch); ok {
+		return refs["refs/tags/"+tag]
+	}
+	if !isBranchPattern(branch) {
+		return refs["refs/heads/"+branch]
+	}
+	for ref := range refs {
+		name, ok := strings.CutPrefix(ref, "refs/heads/")
+		if matched, _ := path.Match(branch, name); ok && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBranchesExist reports for every selected environment whether its
+// branch exists on its remote, listing the refs of each remote once
+func checkBranchesExist(selectedEnvs []string, quick bool) (map[string]bool, error) {
+	refsByRemote := make(map[string]map[string]bool)
+	result := make(map[string]bool)
+	for _, eb := range allBranches {
+		if !containsString(selectedEnvs, eb.Env) {
+			continue
+		}
+		remote := remoteForEnv(eb.Env)
+		refs, ok := refsByRemote[remote]
+		if !ok {
+			var err error
+			refs, err = listRefs(remote, quick)
+			if err != nil {
+				return nil, err
+			}
+			refsByRemote[remote] = refs
+		}
+		result[eb.Env] = branchExists(refs, eb.Branch)
+	}
+	return result, nil
+}
+
+// addDiscoveredBranches appends an environment named after the final path
+// segment of every discovered branch. Branches that are already mapped and
+// environments that already exist are left alone, so explicit mappings win.
+func addDiscoveredBranches(branches []envBranch, discovered []string) []envBranch {
+	result := append([]envBranch(nil), branches...)
+	for _, branch := range discovered {
+		envName := path.Base(branch)
+		known := false
+		for _, eb := range result {
+			if eb.Branch == branch || eb.Env == envName {
+				known = true
+				break
+			}
+		}
+		if !known {
+			result = append(result, envBranch{Env: envName, Branch: branch})
+		}
+	}
+	return result
+}
+
+// tagName returns the tag of a tag:<name> mapping
+func tagName(branch string) (string, bool) {
+	return strings.CutPrefix(branch, "tag:")
+}
+
+func isBranchPattern(branch string) bool {
+	return strings.ContainsAny(branch, "*?[")
+}
+
+// resolveBranchPattern returns the most recently committed branch matching
+// pattern among the remote-tracking branches of remote. A branch that only
+// exists locally can't be reset to the remote afterwards, so local branches
+// are only considered in bare repositories, which is where mirror clones keep
+// them.
+func resolveBranchPattern(pattern, remote string) (string, error) {
+	remotePrefix := "refs/remotes/" + remote + "/"
+	refs := []string{remotePrefix}
+	if bareRepo {
+		refs = append(refs, "refs/heads/")
+	}
+	output, err := runGit(append([]string{"for-each-ref", "--sort=-committerdate", "--format=%(refname)"}, refs...)...)
+	if err != nil {
+		return "", fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	for _, ref := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		var branch string
+		if strings.HasPrefix(ref, remotePrefix) {
+			branch = strings.TrimPrefix(ref, remotePrefix)
+		} else {
+			branch = strings.TrimPrefix(ref, "refs/heads/")
+		}
+		if branch == "HEAD" {
+			continue
+		}
+
+		if matched, _ := path.Match(pattern, branch); matched {
+			return branch, nil
+		}
+	}
+
+	return "", fmt.Errorf("no branch matches pattern '%s'", pattern)
+}
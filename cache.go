@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// blobCacheKey identifies an extraction. The file path is part of the key
+// since its extension selects the parser.
+type blobCacheKey struct {
+	Blob     string
+	FilePath string
+	VarName  string
+}
+
+type blobCacheEntry struct {
+	revision string
+	err      error
+}
+
+// blobCache holds the revisions extracted during this process keyed by the
+// blob hash of the revision file. Branches sharing history point to the same
+// blobs, so each version of the file is only read and parsed once.
+var (
+	blobCacheMu sync.Mutex
+	blobCache   = make(map[blobCacheKey]blobCacheEntry)
+)
+
+func cachedRevision(key blobCacheKey) (blobCacheEntry, bool) {
+	blobCacheMu.Lock()
+	defer blobCacheMu.Unlock()
+	entry, ok := blobCache[key]
+	return entry, ok
+}
+
+// extractAndCache parses content, the blob of key read at ref, and caches
+// the result. An LFS pointer is replaced by its content first.
+func extractAndCache(key blobCacheKey, ref string, source revisionSource, content []byte) (string, error) {
+	if isLFSPointer(string(content)) {
+		smudged, err := smudgeLFSPointer(ref, source.FilePath)
+		if err != nil {
+			return "", err
+		}
+		content = []byte(smudged)
+	}
+
+	var entry blobCacheEntry
+	entry.revision, entry.err = extractRevisionFromContent(string(content), source.FilePath, source.VarName)
+	if entry.err != nil && !noFollowLinks {
+		// The content may be the target path of a symlink, which isn't
+		// worth an extra git call per commit up front. The same link can
+		// point to different content at another commit, so the result is
+		// cached for the target's blob only.
+		resolved, err := resolveSymlinkAtRef(ref, source.FilePath)
+		if err == nil && resolved != source.FilePath {
+			target := source
+			target.FilePath = resolved
+			return extractRevisionAtCommit(ref, target)
+		}
+	}
+
+	blobCacheMu.Lock()
+	blobCache[key] = entry
+	blobCacheMu.Unlock()
+	return entry.revision, entry.err
+}
+
+// extractRevisionAtCommit reads the revision from the version of the revision
+// file at ref, using the cache if that blob was read before
+func extractRevisionAtCommit(ref string, source revisionSource) (string, error) {
+	output, err := runGit("rev-parse", "--verify", "--quiet", ref+":"+source.FilePath)
+	if err != nil {
+		// e.g. the file was deleted or renamed by this commit
+		return "", fmt.Errorf("failed to read '%s' at %s: %w", source.FilePath, ref, err)
+	}
+	key := blobCacheKey{
+		Blob:     strings.TrimSpace(string(output)),
+		FilePath: source.FilePath,
+		VarName:  source.VarName,
+	}
+
+	if entry, ok := cachedRevision(key); ok {
+		return entry.revision, entry.err
+	}
+
+	content, err := runGit("cat-file", "blob", key.Blob)
+	if err != nil {
+		// Not cached, a failing git command may succeed next time
+		return "", fmt.Errorf("failed to read '%s' at %s: %w", source.FilePath, ref, err)
+	}
+	return extractAndCache(key, ref, source, content)
+}
+
+// errMissingObject means a commit, tree or blob needed to read the revision
+// file isn't in the local repository, e.g. beyond the boundary of a shallow
+// clone. Unlike a file missing at a commit it can be fixed by fetching.
+var errMissingObject = errors.New("object missing from the local repository")
+
+// isMissingObject tells whether git cat-file reported ref:filePath as missing
+// because an object isn't available locally rather than because the file
+// doesn't exist at ref. git ls-tree fails on missing trees and lists the file
+// if only its blob is missing.
+func isMissingObject(ref, filePath string) bool {
+	output, err := runGit("ls-tree", ref, "--", filePath)
+	return err != nil || len(bytes.TrimSpace(output)) > 0
+}
+
+// extractRevisionsAtCommits is extractRevisionAtCommit for many commits at
+// once, source[i] being read at refs[i]. Instead of two git calls per commit
+// the blobs are looked up with a single git cat-file --batch-check and the
+// ones not cached yet read with a single git cat-file --batch. The returned
+// slices hold the revision or the error of each commit, which matches
+// errMissingObject if the commit's objects aren't available locally. The
+// error is only set if git itself failed.
+func extractRevisionsAtCommits(refs []string, sources []revisionSource) ([]string, []error, error) {
+	revisions := make([]string, len(refs))
+	errs := make([]error, len(refs))
+	if len(refs) == 0 {
+		return revisions, errs, nil
+	}
+
+	var input strings.Builder
+	for i, ref := range refs {
+		fmt.Fprintf(&input, "%s:%s\n", ref, sources[i].FilePath)
+	}
+	output, err := runGitInput(input.String(), "cat-file", "--batch-check=%(objectname) %(objecttype)")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to look up the revision file: %w", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	if len(lines) != len(refs) {
+		return nil, nil, fmt.Errorf("failed to look up the revision file: git cat-file returned %d objects for %d commits", len(lines), len(refs))
+	}
+
+	keys := make([]blobCacheKey, len(refs))
+	var toRead []string
+	queued := make(map[string]bool)
+	for i, line := range lines {
+		blob, objectType, _ := strings.Cut(line, " ")
+		if objectType != "blob" {
+			// Missing objects are reported as "<ref>:<path> missing", e.g. the
+			// file was deleted or renamed by this commit
+			if isMissingObject(refs[i], sources[i].FilePath) {
+				errs[i] = fmt.Errorf("failed to read '%s' at %s: %w", sources[i].FilePath, refs[i], errMissingObject)
+				continue
+			}
+			errs[i] = markError(ErrFileNotFound, fmt.Errorf("failed to read '%s' at %s: no such file", sources[i].FilePath, refs[i]))
+			continue
+		}
+
+		keys[i] = blobCacheKey{Blob: blob, FilePath: sources[i].FilePath, VarName: sources[i].VarName}
+		if entry, ok := cachedRevision(keys[i]); ok {
+			revisions[i], errs[i] = entry.revision, entry.err
+			keys[i] = blobCacheKey{}
+			continue
+		}
+		if !queued[blob] {
+			queued[blob] = true
+			toRead = append(toRead, blob)
+		}
+	}
+	if len(toRead) == 0 {
+		return revisions, errs, nil
+	}
+
+	output, err = runGitInput(strings.Join(toRead, "\n")+"\n", "cat-file", "--batch")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the revision file: %w", err)
+	}
+	contents, err := parseCatFileBatch(output)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the revision file: %w", err)
+	}
+
+	for i, key := range keys {
+		if key.Blob == "" {
+			continue // Missing or cached
+		}
+		// The same blob may be parsed for several paths or variable names,
+		// so the cache is checked again
+		if entry, ok := cachedRevision(key); ok {
+			revisions[i], errs[i] = entry.revision, entry.err
+			continue
+		}
+		content, ok := contents[key.Blob]
+		if !ok {
+			errs[i] = fmt.Errorf("failed to read '%s' at %s: %w", sources[i].FilePath, refs[i], errMissingObject)
+			continue
+		}
+		revisions[i], errs[i] = extractAndCache(key, refs[i], sources[i], content)
+	}
+	return revisions, errs, nil
+}
+
+// refetchMissingObjects fetches the commits from remote so their objects are
+// available locally: first only the commits themselves, which servers may
+// refuse for commits that aren't at the tip of a ref, then, for a shallow
+// clone, the full history
+func refetchMissingObjects(remote string, commits []string) error {
+	_, err := runGit(append([]string{"fetch", remote}, commits...)...)
+	if err == nil {
+		return nil
+	}
+	output, shallowErr := runGit("rev-parse", "--is-shallow-repository")
+	if shallowErr != nil || strings.TrimSpace(string(output)) != "true" {
+		return err
+	}
+	_, err = runGit("fetch", "--unshallow", remote)
+	return err
+}
+
+// parseCatFileBatch splits the output of git cat-file --batch, a
+// "<hash> <type> <size>" header line followed by the content and a newline
+// for every object, into the contents keyed by hash. Objects git reports as
+// "<hash> missing" are left out.
+func parseCatFileBatch(output []byte) (map[string][]byte, error) {
+	contents := make(map[string][]byte)
+	for len(output) > 0 {
+		header, rest, ok := bytes.Cut(output, []byte("\n"))
+		if !ok {
+			return nil, fmt.Errorf("truncated git cat-file output")
+		}
+		fields := strings.Fields(string(header))
+		if len(fields) == 2 && fields[1] == "missing" {
+			output = rest
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected git cat-file header '%s'", header)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil || size < 0 || size+1 > len(rest) {
+			return nil, fmt.Errorf("unexpected git cat-file header '%s'", header)
+		}
+		contents[fields[0]] = rest[:size]
+		output = rest[size+1:]
+	}
+	return contents, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var compareFormat string
+
+var compareCmd = &cobra.Command{
+	Use:   "compare <dirA> <dirB>",
+	Short: "Compare the tip revisions of two clones, e.g. a fork and its upstream",
+	Long: `Processes the branches of the selected environments in both repositories and reports per
+environment whether the tip revisions match and how far apart their commit dates are.
+Exits with code 4 if any environment differs.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runCompare,
+}
+
+func init() {
+	compareCmd.Flags().BoolVarP(&quickMode, "quick", "q", false, "Skip git fetch/reset operations and use the repositories as-is")
+	compareCmd.Flags().StringVarP(&envList, "envs", "e", "", "Comma-separated list of environments to compare (int,stg,prod). If not specified, all environments are compared.")
+	compareCmd.Flags().StringVar(&revFile, "revision-file", "./hcp/Revision.mk", "Path of the revision file inside the repositories")
+	compareCmd.Flags().StringVar(&varName, "var-name", "ARO_HCP_REPO_REVISION", "Variable (Makefile) or key (YAML/JSON, dot-separated for nested keys) holding the revision")
+	compareCmd.Flags().StringVarP(&compareFormat, "format", "f", "text", "Output format (text, json)")
+	rootCmd.AddCommand(compareCmd)
+}
+
+// envComparison is the result of comparing one environment across two
+// repositories. The date difference is B minus A, so it is positive if B's
+// revision was pinned later.
+type envComparison struct {
+	Environment           string `json:"environment"`
+	Match                 bool   `json:"match"`
+	RevisionA             string `json:"revision_a"`
+	RevisionB             string `json:"revision_b"`
+	CommitDateA           string `json:"commit_date_a"`
+	CommitDateB           string `json:"commit_date_b"`
+	DateDifferenceSeconds *int64 `json:"date_difference_seconds,omitempty"`
+}
+
+func runCompare(cmd *cobra.Command, args []string) {
+	if compareFormat != "text" && compareFormat != "json" {
+		fmt.Fprintf(os.Stderr, "Error: invalid format '%s'. Valid formats are: text, json\n", compareFormat)
+		os.Exit(ExitUsage)
+	}
+
+	selectedEnvs, err := parseEnvironments(envList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+
+	// Each repository is processed from inside its directory
+	var dirs []string
+	for _, dir := range args {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid directory '%s': %v\n", dir, err)
+			os.Exit(ExitUsage)
+		}
+		dirs = append(dirs, absDir)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	runCtx = ctx
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	var results []map[string][]CommitInfo
+	for i, dir := range dirs {
+		result, failure, err := collectResultsInDirectory(dir, selectedEnvs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitCodeForError(err))
+		}
+		if ctx.Err() != nil {
+			fmt.Fprintf(os.Stderr, "Interrupted while processing '%s'\n", args[i])
+			os.Exit(ExitInterrupted)
+		}
+		// A branch that couldn't be read isn't drift, so it is reported with
+		// its own exit code instead of as a mismatch
+		if failure != nil {
+			fmt.Fprintf(os.Stderr, "Error: not all environments of '%s' could be processed\n", args[i])
+			os.Exit(exitCodeForError(failure))
+		}
+		results = append(results, result)
+	}
+
+	comparisons := compareResults(selectedEnvs, results[0], results[1])
+
+	if compareFormat == "json" {
+		jsonData, err := json.MarshalIndent(comparisons, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error marshalling JSON: %v\n", err)
+			os.Exit(ExitOutputFailure)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		fmt.Print(formatComparisons(comparisons))
+	}
+
+	for _, c := range comparisons {
+		if !c.Match {
+			os.Exit(ExitGateFailure)
+		}
+	}
+}
+
+// collectResultsInDirectory runs collectResults inside the repository at
+// directory and restores its checked out branch and the working directory
+// afterwards. Like collectResults it returns the results of the branches that
+// could be processed along with the first branch failure, while err is set if
+// the repository couldn't be processed at all.
+func collectResultsInDirectory(directory string, selectedEnvs []string) (result map[string][]CommitInfo, failure error, err error) {
+	originalDir, err := os.Getwd()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.Chdir(directory); err != nil {
+		return nil, nil, fmt.Errorf("failed to change to directory '%s': %w", directory, err)
+	}
+	defer os.Chdir(originalDir)
+
+	bareRepo, err = isBareRepository()
+	if err != nil {
+		return nil, nil, fmt.Errorf("'%s' is not a git repository: %w", directory, err)
+	}
+	if err := checkRepositoryState(); err != nil {
+		return nil, nil, fmt.Errorf("repository '%s' can't be checked: %w", directory, err)
+	}
+
+	originalRef, err := getCurrentRef()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to determine the checked out branch of '%s': %w", directory, err)
+	}
+	defer restoreOriginalRef(originalRef)
+
+	result, _, failure = collectResults(selectedEnvs)
+	return result, failure, nil
+}
+
+func compareResults(selectedEnvs []string, resultA, resultB map[string][]CommitInfo) []envComparison {
+	envNames := append([]string(nil), selectedEnvs...)
+	sortEnvNames(envNames)
+
+	comparisons := []envComparison{}
+	for _, envName := range envNames {
+		c := envComparison{Environment: envName}
+		if commits := resultA[envName]; len(commits) > 0 {
+			c.RevisionA, c.CommitDateA = commits[0].RepoRevision, commits[0].CommitDate
+		}
+		if commits := resultB[envName]; len(commits) > 0 {
+			c.RevisionB, c.CommitDateB = commits[0].RepoRevision, commits[0].CommitDate
+		}
+		// An environment without a revision on either side never matches
+		c.Match = c.RevisionA != "" && c.RevisionA == c.RevisionB
+
+		dateA, errA := parseCommitDate(c.CommitDateA)
+		dateB, errB := parseCommitDate(c.CommitDateB)
+		if errA == nil && errB == nil {
+			seconds := int64(dateB.Sub(dateA).Seconds())
+			c.DateDifferenceSeconds = &seconds
+		}
+
+		comparisons = append(comparisons, c)
+	}
+	return comparisons
+}
+
+func formatComparisons(comparisons []envComparison) string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENVIRONMENT\tMATCH\tREVISION A\tREVISION B\tDATE DIFFERENCE")
+	for _, c := range comparisons {
+		match := "no"
+		if c.Match {
+			match = "yes"
+		}
+		dateDiff := "-"
+		if c.DateDifferenceSeconds != nil {
+			dateDiff = (time.Duration(*c.DateDifferenceSeconds) * time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Environment, match, orDash(c.RevisionA), orDash(c.RevisionB), dateDiff)
+	}
+	w.Flush()
+	return sb.String()
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
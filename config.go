@@ -0,0 +1,327 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configTimeout limits fetching a --config URL
+const configTimeout = 30 * time.Second
+
+// config is the file given with --config
+type config struct {
+	Environments []envConfig `yaml:"environments"`
+}
+
+// envConfig configures one environment. Empty fields fall back to the
+// defaults and flags.
+type envConfig struct {
+	Name string `yaml:"name"`
+	// Branch maps the environment like --branch, which takes precedence
+	Branch string `yaml:"branch"`
+	// VarName overrides --var-name for this environment
+	VarName string `yaml:"var_name"`
+	// Remote overrides --remote like --env-remote, which takes precedence
+	Remote string `yaml:"remote"`
+	// Aliases are other names the environment can be selected by
+	Aliases []string `yaml:"aliases"`
+}
+
+// envVarNames holds the per-environment variable names from the config
+var envVarNames = map[string]string{}
+
+// envRemotes holds the per-environment remotes from the config and
+// --env-remote
+var envRemotes = map[string]string{}
+
+// envAliases maps the aliases from the config to their environment names
+var envAliases = map[string]string{}
+
+func loadConfig(path string) (*config, error) {
+	var content []byte
+	var err error
+	if isConfigURL(path) {
+		content, err = fetchConfig(path)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file '%s': %v", path, err)
+	}
+
+	var cfg config
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file '%s': %v", path, err)
+	}
+
+	names := make(map[string]bool)
+	for _, env := range cfg.Environments {
+		if env.Name == "" {
+			return nil, fmt.Errorf("config file '%s' has an environment without a name", path)
+		}
+		names[env.Name] = true
+	}
+	aliases := make(map[string]string)
+	for _, env := range cfg.Environments {
+		for _, alias := range env.Aliases {
+			if alias == "" || strings.Contains(alias, ",") {
+				return nil, fmt.Errorf("config file '%s' has an invalid alias '%s' for environment '%s'", path, alias, env.Name)
+			}
+			if names[alias] {
+				return nil, fmt.Errorf("alias '%s' of environment '%s' in config file '%s' is the name of an environment", alias, env.Name, path)
+			}
+			if other, ok := aliases[alias]; ok && other != env.Name {
+				return nil, fmt.Errorf("alias '%s' in config file '%s' is used for both '%s' and '%s'", alias, path, other, env.Name)
+			}
+			aliases[alias] = env.Name
+		}
+	}
+	return &cfg, nil
+}
+
+// applyConfig applies the environments of cfg to allBranches, envVarNames,
+// envRemotes and envAliases. New environments need a branch.
+func applyConfig(cfg *config) error {
+	var mappings []string
+	for _, env := range cfg.Environments {
+		if env.Branch != "" {
+			mappings = append(mappings, env.Name+"="+env.Branch)
+		} else if !isKnownEnv(env.Name) {
+			return fmt.Errorf("environment '%s' in the config file has no branch", env.Name)
+		}
+		if env.VarName != "" {
+			envVarNames[env.Name] = env.VarName
+		}
+		if env.Remote != "" {
+			envRemotes[env.Name] = env.Remote
+		}
+		for _, alias := range env.Aliases {
+			if isKnownEnv(alias) {
+				return fmt.Errorf("alias '%s' of environment '%s' in the config file is the name of an environment", alias, env.Name)
+			}
+			envAliases[alias] = env.Name
+		}
+	}
+
+	var err error
+	allBranches, err = applyBranchMappings(allBranches, mappings)
+	return err
+}
+
+func isKnownEnv(envName string) bool {
+	for _, eb := range allBranches {
+		if eb.Env == envName {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEnvRemotes applies env=remote mappings to envRemotes. The environments
+// must be known, so this runs after the branch mappings.
+func applyEnvRemotes(mappings []string) error {
+	for _, mapping := range mappings {
+		env, remote, ok := strings.Cut(mapping, "=")
+		env = strings.TrimSpace(env)
+		remote = strings.TrimSpace(remote)
+		if !ok || env == "" || remote == "" {
+			return fmt.Errorf("invalid remote mapping '%s', expected env=remote", mapping)
+		}
+		if !isKnownEnv(env) {
+			return fmt.Errorf("invalid remote mapping '%s', unknown environment '%s'", mapping, env)
+		}
+		envRemotes[env] = remote
+	}
+	return nil
+}
+
+// envRefs holds the commit-ish each environment is pinned to with --ref
+var envRefs = map[string]string{}
+
+// applyEnvRefs applies the env=commitish mappings of --ref to envRefs
+func applyEnvRefs(mappings []string) error {
+	for _, mapping := range mappings {
+		env, ref, ok := strings.Cut(mapping, "=")
+		env = strings.TrimSpace(env)
+		ref = strings.TrimSpace(ref)
+		if !ok || env == "" || ref == "" {
+			return fmt.Errorf("invalid ref mapping '%s', expected env=commitish", mapping)
+		}
+		if !isKnownEnv(env) {
+			return fmt.Errorf("invalid ref mapping '%s', unknown environment '%s'", mapping, env)
+		}
+		envRefs[env] = ref
+	}
+	return nil
+}
+
+// canonicalEnv returns the environment an alias from the config stands for,
+// or envName itself if it isn't an alias
+func canonicalEnv(envName string) string {
+	if canonical, ok := envAliases[envName]; ok {
+		return canonical
+	}
+	return envName
+}
+
+// resolveEnvAliases replaces the config aliases in every flag naming
+// environments with the environment names, so an alias works wherever a name
+// does and the output is keyed by the names. It runs once the config is
+// applied, before any of these flags is parsed.
+func resolveEnvAliases() {
+	if len(envAliases) == 0 {
+		return
+	}
+	envList = resolveListAliases(envList)
+	exclEnvList = resolveListAliases(exclEnvList)
+	daysSpec = resolveListAliases(daysSpec)
+	baselineEnv = canonicalEnv(baselineEnv)
+	for _, mappings := range []*[]string{&branchMaps, &envRemoteMaps, &envRefMaps} {
+		for i, mapping := range *mappings {
+			(*mappings)[i] = resolveAlias(mapping)
+		}
+	}
+}
+
+// resolveListAliases resolves the aliases of a comma-separated list of
+// environments or env=value entries
+func resolveListAliases(list string) string {
+	if list == "" {
+		return list
+	}
+	entries := strings.Split(list, ",")
+	for i, entry := range entries {
+		entries[i] = resolveAlias(entry)
+	}
+	return strings.Join(entries, ",")
+}
+
+// resolveAlias resolves the alias of an environment or env=value entry,
+// leaving anything else as it is
+func resolveAlias(entry string) string {
+	env, value, isMapping := strings.Cut(entry, "=")
+	canonical, ok := envAliases[strings.TrimSpace(env)]
+	if !ok {
+		return entry
+	}
+	if isMapping {
+		return canonical + "=" + value
+	}
+	return canonical
+}
+
+// remoteForEnv returns the remote an environment's branch is read from
+func remoteForEnv(envName string) string {
+	if remote, ok := envRemotes[envName]; ok {
+		return remote
+	}
+	return remoteName
+}
+
+// varNameForEnv returns the variable holding the revision for an environment
+func varNameForEnv(envName string) string {
+	if name, ok := envVarNames[envName]; ok {
+		return name
+	}
+	return varName
+}
+
+func isConfigURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// fetchConfig downloads the config at configURL with the --config-header
+// headers. Every successful download is cached, and if the server can't be
+// reached later or fails with a 5xx status the cached copy is used as long as
+// it isn't older than --config-cache-ttl, so brief outages don't break the
+// runs. Other failures such as a rejected token are never papered over.
+func fetchConfig(configURL string) ([]byte, error) {
+	content, outage, err := downloadConfig(configURL)
+	if configCacheTTL <= 0 {
+		return content, err
+	}
+
+	cachePath, cacheErr := configCachePath(configURL)
+	if err == nil {
+		if cacheErr == nil {
+			cacheErr = os.MkdirAll(filepath.Dir(cachePath), 0o700)
+		}
+		if cacheErr == nil {
+			cacheErr = writeFileAtomic(cachePath, content)
+		}
+		if cacheErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to cache config '%s': %v\n", configURL, cacheErr)
+		}
+		return content, nil
+	}
+	if !outage || cacheErr != nil {
+		return nil, err
+	}
+
+	info, statErr := os.Stat(cachePath)
+	if statErr != nil {
+		return nil, err
+	}
+	age := time.Since(info.ModTime())
+	if age > configCacheTTL {
+		return nil, fmt.Errorf("%v, and the cached copy is older than %s", err, configCacheTTL)
+	}
+	cached, readErr := os.ReadFile(cachePath)
+	if readErr != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %v, using the copy cached %s ago\n", err, age.Round(time.Second))
+	return cached, nil
+}
+
+// downloadConfig downloads the config at configURL. outage tells whether a
+// failure is the server's fault rather than the request's.
+func downloadConfig(configURL string) (content []byte, outage bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, configURL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, header := range configHeaders {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, false, fmt.Errorf("invalid --config-header '%s', expected 'Name: value'", header)
+		}
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	client := &http.Client{Timeout: configTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode >= 500, fmt.Errorf("GET %s returned %s", configURL, resp.Status)
+	}
+	content, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to read response from %s: %v", configURL, err)
+	}
+	return content, false, nil
+}
+
+// configCachePath returns where the config downloaded from configURL is
+// cached, named after the hash of the URL
+func configCachePath(configURL string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(configURL))
+	return filepath.Join(cacheDir, "repo-rev-checker", "config-"+hex.EncodeToString(sum[:])+".yaml"), nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// outputDateLayout is the layout of commit dates in the output. Dates are
+// always printed in UTC, so the offset is +0000.
+const outputDateLayout = "2006-01-02 15:04:05 -0700"
+
+// commitDateLayouts are the commit date layouts parseCommitDate accepts: strict
+// ISO 8601 as printed by git's %cI, with a numeric offset or Z, the same
+// without the colon in the offset, and the output layout, which is also what
+// git's default date format reduces to with --date=iso.
+var commitDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700",
+	outputDateLayout,
+}
+
+// parseCommitDate parses a commit date in any of commitDateLayouts. The
+// offset is kept, so the result is the same instant regardless of the local
+// time zone.
+func parseCommitDate(dateStr string) (time.Time, error) {
+	for _, layout := range commitDateLayouts {
+		if t, err := time.Parse(layout, dateStr); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("failed to parse date '%s': unsupported date format", dateStr)
+}
+
+// formatCommitDate converts a commit date to UTC and formats it with layout
+func formatCommitDate(dateStr, layout string) (string, error) {
+	t, err := parseCommitDate(dateStr)
+	if err != nil {
+		return "", err
+	}
+	return t.UTC().Format(layout), nil
+}
+
+// convertToUTC converts a commit date as printed by git's %cI into the output
+// format. Unlike --date or log.date, %cI isn't affected by the user's git
+// configuration, so the layout is always the same.
+func convertToUTC(dateStr string) (string, error) {
+	return formatCommitDate(dateStr, outputDateLayout)
+}
+
+// relativeTime describes the time from t to now for humans, e.g. "3 days
+// ago", using the largest whole unit
+func relativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+
+	var n int
+	var unit string
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		n, unit = int(d/time.Minute), "minute"
+	case d < 24*time.Hour:
+		n, unit = int(d/time.Hour), "hour"
+	default:
+		n, unit = int(d/(24*time.Hour)), "day"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s ago", n, unit)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestConvertToUTCAcrossDST(t *testing.T) {
+	tests := []struct {
+		name string
+		date string
+		want string
+	}{
+		// America/New_York springs forward on 2025-03-09 at 02:00 EST
+		{name: "before spring forward", date: "2025-03-09T01:59:59-05:00", want: "2025-03-09 06:59:59 +0000"},
+		{name: "after spring forward", date: "2025-03-09T03:00:00-04:00", want: "2025-03-09 07:00:00 +0000"},
+		{name: "after spring forward without colon", date: "2025-03-09T03:00:00-0400", want: "2025-03-09 07:00:00 +0000"},
+		// and falls back on 2025-11-02 at 02:00 EDT, so 01:30 happens twice
+		{name: "first 01:30 in November", date: "2025-11-02T01:30:00-04:00", want: "2025-11-02 05:30:00 +0000"},
+		{name: "second 01:30 in November", date: "2025-11-02T01:30:00-05:00", want: "2025-11-02 06:30:00 +0000"},
+		{name: "after fall back in UTC", date: "2025-11-02T07:00:00Z", want: "2025-11-02 07:00:00 +0000"},
+		{name: "output layout", date: "2025-11-02 06:30:00 +0000", want: "2025-11-02 06:30:00 +0000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertToUTC(tt.date)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCommitDateAcrossDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("failed to load time zone: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		start time.Time
+	}{
+		{name: "spring forward", start: time.Date(2025, time.March, 9, 6, 0, 0, 0, time.UTC)},
+		{name: "fall back", start: time.Date(2025, time.November, 2, 5, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Commits every 30 minutes across the transition, with the dates
+			// as git prints them for a committer in New York
+			var previous time.Time
+			for i := 0; i < 6; i++ {
+				instant := tt.start.Add(time.Duration(i) * 30 * time.Minute)
+				date := instant.In(newYork).Format(time.RFC3339)
+
+				parsed, err := parseCommitDate(date)
+				if err != nil {
+					t.Fatalf("failed to parse %q: %v", date, err)
+				}
+				if !parsed.Equal(instant) {
+					t.Errorf("%q parsed as %s, want %s", date, parsed.UTC(), instant)
+				}
+				if i > 0 && parsed.Sub(previous) != 30*time.Minute {
+					t.Errorf("%q is %s after the previous commit, want 30m", date, parsed.Sub(previous))
+				}
+				previous = parsed
+
+				formatted, err := formatCommitDate(date, outputDateLayout)
+				if err != nil {
+					t.Fatalf("failed to format %q: %v", date, err)
+				}
+				if want := instant.Format(outputDateLayout); formatted != want {
+					t.Errorf("%q formatted as %q, want %q", date, formatted, want)
+				}
+			}
+		})
+	}
+}
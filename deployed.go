@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// deployedTimeout limits each request to --deployed-url-template
+const deployedTimeout = 30 * time.Second
+
+// checkDeployedRevisions fetches the deployed revision of every environment
+// in result from --deployed-url-template and records in meta whether it
+// matches the tip revision. Fetch failures are reported but don't fail the
+// run.
+func checkDeployedRevisions(result map[string][]CommitInfo, meta resultMeta) {
+	client := &http.Client{Timeout: deployedTimeout}
+
+	for _, envName := range sortedEnvNames(result) {
+		pinned := tipRevision(result[envName])
+		if pinned == "" {
+			continue
+		}
+
+		deployed, err := fetchDeployedRevision(client, envName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching deployed revision for environment '%s': %v\n", envName, err)
+			continue
+		}
+
+		match := revisionMatches(deployed, pinned)
+		if !match {
+			fmt.Fprintf(os.Stderr, "Warning: environment '%s' runs revision '%s' but pins '%s'\n", envName, deployed, pinned)
+		}
+
+		envInfo, ok := meta.Environments[envName]
+		if !ok {
+			envInfo = &envMeta{}
+			meta.Environments[envName] = envInfo
+		}
+		envInfo.DeployedRevision = deployed
+		envInfo.DeployedMatch = &match
+	}
+}
+
+// fetchDeployedRevision requests --deployed-url-template with {env} replaced
+// and returns the --deployed-field of the JSON response
+func fetchDeployedRevision(client *http.Client, envName string) (string, error) {
+	requestURL := strings.ReplaceAll(deployedURLTmpl, "{env}", url.PathEscape(envName))
+
+	req, err := http.NewRequestWithContext(runCtx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s returned %s", requestURL, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response from %s: %v", requestURL, err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", fmt.Errorf("failed to parse response from %s: %v", requestURL, err)
+	}
+	revision, err := lookupRevisionKey(doc, deployedField)
+	if err != nil {
+		return "", fmt.Errorf("response from %s: %w", requestURL, err)
+	}
+	if revision == "" {
+		return "", fmt.Errorf("response from %s has an empty %s", requestURL, deployedField)
+	}
+	return revision, nil
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var diagnoseEnv string
+
+var diagnoseCmd = &cobra.Command{
+	Use:   "diagnose <directory>",
+	Short: "Explain step by step why an environment has no revision",
+	Long: `Runs the processing steps for a single environment one at a time and prints a PASS or FAIL line
+for each: the environment's branch, the state of the repository, the fetch, the remote ref,
+the checkout, the revision file and the variable in it. Stops at the first failing step, which
+is the reason the environment is missing from the output, and exits with the code the check
+would fail with. Like the check itself it checks out and resets the branch unless --quick or
+--ref-prefix is given, and restores the checked out branch afterwards.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runDiagnose,
+}
+
+func init() {
+	diagnoseCmd.Flags().StringVar(&diagnoseEnv, "env", "", "Environment to diagnose (int, stg, prod)")
+	diagnoseCmd.Flags().BoolVarP(&quickMode, "quick", "q", false, "Skip git fetch/reset operations and use repository as-is")
+	diagnoseCmd.Flags().StringVar(&revFile, "revision-file", "./hcp/Revision.mk", "Path of the revision file inside the repository")
+	diagnoseCmd.Flags().StringVar(&varName, "var-name", "ARO_HCP_REPO_REVISION", "Variable (Makefile) or key (YAML/JSON, dot-separated for nested keys) holding the revision")
+	diagnoseCmd.Flags().BoolVar(&caseInsensitive, "case-insensitive", false, "Match the Makefile variable of --var-name regardless of case")
+	diagnoseCmd.Flags().StringVar(&remoteName, "remote", "origin", "Remote the branch is fetched from and reset to")
+	diagnoseCmd.Flags().BoolVar(&allowLocal, "allow-local", false, "Read the local branch as-is when the remote has no such branch instead of failing")
+	diagnoseCmd.Flags().StringVar(&configFile, "config", "", "YAML file or http(s) URL configuring environments (name, branch, var_name)")
+	diagnoseCmd.Flags().StringArrayVar(&configHeaders, "config-header", nil, "Header sent when fetching a --config URL as 'Name: value', e.g. for authentication. Can be repeated.")
+	diagnoseCmd.Flags().DurationVar(&configCacheTTL, "config-cache-ttl", time.Hour, "Use the cached copy of a --config URL if fetching it fails and the copy isn't older than this. 0 disables the cache.")
+	diagnoseCmd.Flags().StringArrayVar(&branchMaps, "branch", nil, "Map an environment to a branch as env=branch, overriding the default or adding a new environment. Can be repeated.")
+	diagnoseCmd.Flags().StringArrayVar(&envRemoteMaps, "env-remote", nil, "Use a different remote for an environment as env=remote, overriding --remote. Can be repeated.")
+	diagnoseCmd.Flags().StringVar(&refPrefix, "ref-prefix", "origin/", "Read the branch from refs/remotes/<ref-prefix><branch> without checking anything out. Only used if given.")
+	diagnoseCmd.MarkFlagRequired("env")
+	rootCmd.AddCommand(diagnoseCmd)
+}
+
+// errStepSkipped is returned by a diagnose step that doesn't apply, e.g. the
+// fetch with --quick
+var errStepSkipped = errors.New("step skipped")
+
+// diagnosis carries what the earlier diagnose steps found to the later ones
+type diagnosis struct {
+	envName string
+	branch  string
+	remote  string
+	source  revisionSource
+	// readRef is the revision the file is read from, HEAD once checked out
+	readRef string
+	// localOnly is set with --allow-local when the remote has no such
+	// branch, so the local one is read as-is
+	localOnly bool
+	// originalRef is restored at the end if checkedOut is set
+	originalRef string
+	checkedOut  bool
+	// restoreDir leaves the submodule holding the revision file, if any
+	restoreDir func()
+}
+
+// diagnoseStep is one step of diagnose. run returns what the step found, or
+// the error the check would fail the environment with.
+type diagnoseStep struct {
+	name string
+	run  func(d *diagnosis) (string, error)
+}
+
+// diagnoseSteps are run in order, each relying on the ones before it
+var diagnoseSteps = []diagnoseStep{
+	{"environment", (*diagnosis).checkEnvironment},
+	{"repository", (*diagnosis).checkRepository},
+	{"fetch", (*diagnosis).fetch},
+	{"remote ref", (*diagnosis).checkRemoteRef},
+	{"checkout", (*diagnosis).checkout},
+	{"revision file", (*diagnosis).checkRevisionFile},
+	{"variable", (*diagnosis).checkVariable},
+	{"commit date", (*diagnosis).checkCommitDate},
+}
+
+func runDiagnose(cmd *cobra.Command, args []string) {
+	// The environment is looked up with the same mappings as in the check
+	noCheckout = cmd.Flags().Changed("ref-prefix")
+	if noCheckout && refPrefix == "" {
+		fmt.Fprintf(os.Stderr, "Error: --ref-prefix must not be empty\n")
+		os.Exit(ExitUsage)
+	}
+	if configFile != "" {
+		cfg, err := loadConfig(configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+		if err := applyConfig(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+		resolveEnvAliases()
+		diagnoseEnv = resolveListAliases(diagnoseEnv)
+	}
+	var err error
+	allBranches, err = applyBranchMappings(allBranches, branchMaps)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+	if err := applyEnvRemotes(envRemoteMaps); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+
+	selectedEnvs, err := parseEnvironments(diagnoseEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+	if len(selectedEnvs) != 1 {
+		fmt.Fprintf(os.Stderr, "Error: --env takes a single environment\n")
+		os.Exit(ExitUsage)
+	}
+
+	if err := os.Chdir(args[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error changing to directory '%s': %v\n", args[0], err)
+		os.Exit(ExitUsage)
+	}
+	directory, _ := filepath.Abs(".")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	runCtx = ctx
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	d := &diagnosis{envName: selectedEnvs[0]}
+	fmt.Printf("Diagnosing environment '%s' in '%s'\n", d.envName, directory)
+
+	var failed error
+	var failedStep string
+	for _, step := range diagnoseSteps {
+		if failed != nil {
+			fmt.Printf("SKIP  %s\n", step.name)
+			continue
+		}
+		detail, err := step.run(d)
+		switch {
+		case errors.Is(err, errStepSkipped):
+			fmt.Printf("SKIP  %-14s %s\n", step.name, detail)
+		case err != nil:
+			fmt.Printf("FAIL  %-14s %v\n", step.name, err)
+			failed, failedStep = err, step.name
+		default:
+			fmt.Printf("PASS  %-14s %s\n", step.name, detail)
+		}
+	}
+
+	if d.restoreDir != nil {
+		d.restoreDir()
+	}
+	if d.checkedOut {
+		restoreOriginalRef(d.originalRef)
+	}
+	if ctx.Err() != nil {
+		os.Exit(ExitInterrupted)
+	}
+	if failed != nil {
+		fmt.Printf("Environment '%s' is missing from the output because the %s step fails\n", d.envName, failedStep)
+		os.Exit(exitCodeForError(failed))
+	}
+	fmt.Printf("Environment '%s' should be reported, all steps pass\n", d.envName)
+}
+
+func (d *diagnosis) checkEnvironment() (string, error) {
+	for _, eb := range allBranches {
+		if eb.Env == d.envName {
+			d.branch = eb.Branch
+		}
+	}
+	d.remote = remoteForEnv(d.envName)
+	d.source = revisionSource{FilePath: revFile, VarName: varNameForEnv(d.envName)}
+	return fmt.Sprintf("branch '%s' on remote '%s', variable %s", d.branch, d.remote, d.source.VarName), nil
+}
+
+func (d *diagnosis) checkRepository() (string, error) {
+	var err error
+	bareRepo, err = isBareRepository()
+	if err != nil {
+		return "", fmt.Errorf("not a git repository: %w", err)
+	}
+	if bareRepo {
+		// Without a working tree paths are relative to the repository root
+		d.source.FilePath = path.Clean(revFile)
+		return "bare repository, the branch is read through git objects", nil
+	}
+	if err := checkRepositoryState(); err != nil {
+		return "", err
+	}
+	d.originalRef, err = getCurrentRef()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine the checked out branch: %w", err)
+	}
+	if noCheckout {
+		return fmt.Sprintf("working tree with '%s' checked out, which --ref-prefix leaves alone", d.originalRef), nil
+	}
+	return fmt.Sprintf("working tree with '%s' checked out, no merge or rebase in progress", d.originalRef), nil
+}
+
+func (d *diagnosis) fetch() (string, error) {
+	if quickMode {
+		return "not fetching with --quick", errStepSkipped
+	}
+	if err := fetchBranches(d.remote, []string{d.branch}); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("fetched '%s' from %s", d.branch, d.remote), nil
+}
+
+func (d *diagnosis) checkRemoteRef() (string, error) {
+	if tag, ok := tagName(d.branch); ok {
+		d.readRef = "refs/tags/" + tag
+		if _, err := runGit("rev-parse", "--verify", "--quiet", d.readRef); err != nil {
+			return "", markError(ErrGitOperation, fmt.Errorf("tag '%s' doesn't exist", tag))
+		}
+		return fmt.Sprintf("%s exists", d.readRef), nil
+	}
+
+	var detail string
+	if isBranchPattern(d.branch) {
+		resolved, err := resolveBranchPattern(d.branch, d.remote)
+		if err != nil {
+			return "", err
+		}
+		detail = fmt.Sprintf("pattern '%s' matches '%s', ", d.branch, resolved)
+		d.branch = resolved
+	}
+
+	switch {
+	case noCheckout:
+		// Like --ref-prefix in the check, read the remote-tracking ref
+		// through git objects
+		d.readRef = "refs/remotes/" + refPrefix + d.branch
+		return d.checkTrackingRef(detail, d.readRef, "remote-tracking ref "+d.readRef)
+	case bareRepo && quickMode:
+		d.readRef = resolveBareRef(d.branch, d.remote)
+		if _, err := runGit("rev-parse", "--verify", "--quiet", d.readRef); err != nil {
+			return "", markError(ErrGitOperation, fmt.Errorf("neither %s/%s nor a branch '%s' exists", d.remote, d.branch, d.branch))
+		}
+		return detail + fmt.Sprintf("%s exists", d.readRef), nil
+	case quickMode:
+		return detail + "not needed with --quick, the local branch is checked out as-is", errStepSkipped
+	}
+
+	remoteRef := "refs/remotes/" + d.remote + "/" + d.branch
+	if bareRepo {
+		d.readRef = remoteRef
+	}
+	return d.checkTrackingRef(detail, remoteRef, "remote branch "+d.remote+"/"+d.branch)
+}
+
+// checkTrackingRef checks that ref, the remote-tracking ref the branch is read
+// from or reset to, exists. With --allow-local a missing one falls back to the
+// local branch, as in the check.
+func (d *diagnosis) checkTrackingRef(detail, ref, name string) (string, error) {
+	if _, err := runGit("rev-parse", "--verify", "--quiet", ref); err == nil {
+		return detail + fmt.Sprintf("%s exists", ref), nil
+	}
+	if !allowLocal {
+		return "", markError(ErrGitOperation, fmt.Errorf("%s doesn't exist, use --allow-local to read the local branch '%s'", name, d.branch))
+	}
+
+	localRef := "refs/heads/" + d.branch
+	if _, err := runGit("rev-parse", "--verify", "--quiet", localRef); err != nil {
+		return "", markError(ErrGitOperation, fmt.Errorf("neither the %s nor the local branch '%s' exists", name, d.branch))
+	}
+	d.localOnly = true
+	if d.readRef == ref {
+		// Read through git objects, a checked out branch is local anyway
+		d.readRef = localRef
+	}
+	return detail + fmt.Sprintf("%s doesn't exist, reading the local branch '%s' as-is with --allow-local", name, d.branch), nil
+}
+
+func (d *diagnosis) checkout() (string, error) {
+	if _, ok := tagName(d.branch); ok {
+		return "tags are read without checking them out", errStepSkipped
+	}
+	if bareRepo {
+		return "nothing to check out in a bare repository", errStepSkipped
+	}
+	if noCheckout {
+		return "--ref-prefix reads the branch through git objects", errStepSkipped
+	}
+
+	d.checkedOut = true
+	if _, err := runGit("checkout", d.branch); err != nil {
+		return "", fmt.Errorf("failed to checkout branch '%s': %w", d.branch, err)
+	}
+	detail := fmt.Sprintf("checked out '%s'", d.branch)
+	if !quickMode && !d.localOnly {
+		remoteRef := d.remote + "/" + d.branch
+		if _, err := runGit("reset", "--hard", remoteRef); err != nil {
+			return "", fmt.Errorf("failed to reset to %s: %w", remoteRef, err)
+		}
+		detail += " and reset it to " + remoteRef
+	}
+	d.readRef = "HEAD"
+	return detail, nil
+}
+
+func (d *diagnosis) checkRevisionFile() (string, error) {
+	var resolved string
+	var err error
+	if d.readRef == "HEAD" {
+		resolved, err = resolveSymlinkedFile(d.source.FilePath)
+	} else {
+		resolved, err = resolveSymlinkAtRef(d.readRef, d.source.FilePath)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve '%s': %w", d.source.FilePath, err)
+	}
+	var detail string
+	if resolved != d.source.FilePath {
+		detail = fmt.Sprintf(", a symlink to '%s'", resolved)
+		d.source.FilePath = resolved
+	}
+
+	// Like the check, read a file inside a submodule from within the
+	// submodule at the commit the superproject points to
+	submodule, err := findSubmodule(d.readRef, d.source.FilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up submodules for '%s': %w", d.source.FilePath, err)
+	}
+	if submodule != nil {
+		if bareRepo {
+			return "", fmt.Errorf("'%s' is inside submodule '%s', which can't be read in a bare repository", d.source.FilePath, submodule.Path)
+		}
+		d.restoreDir, err = enterSubmodule(submodule.Path)
+		if err != nil {
+			return "", err
+		}
+		detail += fmt.Sprintf(", inside submodule '%s'", submodule.Path)
+		d.readRef, d.source.FilePath = submodule.Commit, submodule.FilePath
+	}
+
+	if _, err := runGit("rev-parse", "--verify", "--quiet", d.readRef+":"+d.source.FilePath); err != nil {
+		return "", markError(ErrFileNotFound, fmt.Errorf("'%s' doesn't exist at %s%s", d.source.FilePath, d.readRef, detail))
+	}
+	return fmt.Sprintf("'%s' exists at %s%s", d.source.FilePath, d.readRef, detail), nil
+}
+
+func (d *diagnosis) checkVariable() (string, error) {
+	revision, err := extractRevisionAtRef(d.readRef, d.source)
+	if err != nil {
+		return "", err
+	}
+	detail := fmt.Sprintf("%s = %s", d.source.VarName, revision)
+	if err := validateRevision(revision); err != nil {
+		// The check reports any value, so this doesn't fail the step
+		detail += fmt.Sprintf(" (note: %v)", err)
+	}
+	return detail, nil
+}
+
+func (d *diagnosis) checkCommitDate() (string, error) {
+	output, err := runGit("log", "-1", "--format=%cI", d.readRef, "--", d.source.FilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get commit date for '%s': %w", d.source.FilePath, err)
+	}
+	date := strings.TrimSpace(string(output))
+	if date == "" {
+		return "", markError(ErrFileNotFound, fmt.Errorf("no commit on %s changed '%s'", d.readRef, d.source.FilePath))
+	}
+	return "last changed " + date, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var diffFormat string
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <old.json> <new.json>",
+	Short: "Show what changed between two saved JSON outputs",
+	Long: `Compares two JSON files produced by repo-rev-checker and reports which environments
+changed their tip revision, and which environments were added or removed. No git repository is needed.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runDiff,
+}
+
+func init() {
+	diffCmd.Flags().StringVarP(&diffFormat, "format", "f", "text", "Output format (text, json)")
+	diffCmd.Flags().StringVar(&keyRevision, "key-revision", "repo_revision", "Name of the revision field in the compared files")
+	diffCmd.Flags().StringVar(&keyDate, "key-date", "commit_date", "Name of the commit date field in the compared files")
+	rootCmd.AddCommand(diffCmd)
+}
+
+type envChange struct {
+	Environment string `json:"environment"`
+	OldRevision string `json:"old_revision"`
+	NewRevision string `json:"new_revision"`
+}
+
+type snapshotDiff struct {
+	Changed []envChange `json:"changed"`
+	Added   []string    `json:"added"`
+	Removed []string    `json:"removed"`
+}
+
+func runDiff(cmd *cobra.Command, args []string) {
+	if diffFormat != "text" && diffFormat != "json" {
+		fmt.Fprintf(os.Stderr, "Error: invalid format '%s'. Valid formats are: text, json\n", diffFormat)
+		os.Exit(ExitUsage)
+	}
+	if err := validateOutputKeys(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+
+	oldResult, err := loadSnapshot(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+
+	newResult, err := loadSnapshot(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+
+	d := diffSnapshots(oldResult, newResult)
+
+	if diffFormat == "json" {
+		jsonData, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error marshalling JSON: %v\n", err)
+			os.Exit(ExitOutputFailure)
+		}
+		fmt.Println(string(jsonData))
+		return
+	}
+
+	fmt.Print(formatSnapshotDiff(d))
+}
+
+func loadSnapshot(path string) (map[string][]CommitInfo, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file '%s': %v", path, err)
+	}
+	if keyRevision != "repo_revision" || keyDate != "commit_date" {
+		content, err = renameOutputKeys(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse '%s': %v", path, err)
+		}
+	}
+
+	// Outputs saved with --with-meta nest the commits under "environments"
+	var withMetaOutput struct {
+		Environments map[string][]CommitInfo `json:"environments"`
+		Meta         json.RawMessage         `json:"meta"`
+	}
+	if err := json.Unmarshal(content, &withMetaOutput); err == nil && withMetaOutput.Meta != nil {
+		return withMetaOutput.Environments, nil
+	}
+
+	var result map[string][]CommitInfo
+	if err := json.Unmarshal(content, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse '%s': %v", path, err)
+	}
+
+	return result, nil
+}
+
+// renameOutputKeys renames the --key-revision and --key-date fields of every
+// commit in a JSON output back to the CommitInfo names, so outputs written
+// with other names can be read like the default ones
+func renameOutputKeys(content []byte) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+
+	renameEnvironments := func(raw json.RawMessage) (json.RawMessage, error) {
+		var environments map[string][]map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &environments); err != nil {
+			return nil, err
+		}
+		for _, commits := range environments {
+			for i, commit := range commits {
+				renamed := make(map[string]json.RawMessage, len(commit))
+				for key, value := range commit {
+					if key != keyRevision && key != keyDate {
+						renamed[key] = value
+					}
+				}
+				// Set last so they win over fields of the same name
+				if value, ok := commit[keyRevision]; ok {
+					renamed["repo_revision"] = value
+				}
+				if value, ok := commit[keyDate]; ok {
+					renamed["commit_date"] = value
+				}
+				commits[i] = renamed
+			}
+		}
+		return json.Marshal(environments)
+	}
+
+	// Outputs saved with --with-meta nest the commits under "environments"
+	if _, ok := doc["meta"]; ok {
+		environments, err := renameEnvironments(doc["environments"])
+		if err != nil {
+			return nil, err
+		}
+		doc["environments"] = environments
+		return json.Marshal(doc)
+	}
+	return renameEnvironments(content)
+}
+
+// diffSnapshots compares the tip revision of every environment in two results
+func diffSnapshots(oldResult, newResult map[string][]CommitInfo) snapshotDiff {
+	d := snapshotDiff{
+		Changed: []envChange{},
+		Added:   []string{},
+		Removed: []string{},
+	}
+
+	for _, envName := range sortedEnvNames(newResult) {
+		oldCommits, ok := oldResult[envName]
+		if !ok {
+			d.Added = append(d.Added, envName)
+			continue
+		}
+
+		oldRevision := tipRevision(oldCommits)
+		newRevision := tipRevision(newResult[envName])
+		if oldRevision != newRevision {
+			d.Changed = append(d.Changed, envChange{
+				Environment: envName,
+				OldRevision: oldRevision,
+				NewRevision: newRevision,
+			})
+		}
+	}
+
+	for _, envName := range sortedEnvNames(oldResult) {
+		if _, ok := newResult[envName]; !ok {
+			d.Removed = append(d.Removed, envName)
+		}
+	}
+
+	return d
+}
+
+func tipRevision(commits []CommitInfo) string {
+	if len(commits) == 0 {
+		return ""
+	}
+	return commits[0].RepoRevision
+}
+
+func formatSnapshotDiff(d snapshotDiff) string {
+	if len(d.Changed) == 0 && len(d.Added) == 0 && len(d.Removed) == 0 {
+		return "No changes\n"
+	}
+
+	var sb strings.Builder
+	for _, change := range d.Changed {
+		fmt.Fprintf(&sb, "changed %s: %s -> %s\n", change.Environment, change.OldRevision, change.NewRevision)
+	}
+	for _, envName := range d.Added {
+		fmt.Fprintf(&sb, "added   %s\n", envName)
+	}
+	for _, envName := range d.Removed {
+		fmt.Fprintf(&sb, "removed %s\n", envName)
+	}
+	return sb.String()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Error categories returned by the processing functions. Use errors.Is to
+// check for them, or errors.As with GitCommandError and ExtractionError for
+// details.
+var (
+	// ErrGitOperation means a git command failed
+	ErrGitOperation = errors.New("git operation failed")
+	// ErrFileNotFound means the revision file doesn't exist
+	ErrFileNotFound = errors.New("revision file not found")
+	// ErrRevisionNotFound means the revision file doesn't contain the variable
+	ErrRevisionNotFound = errors.New("revision not found")
+	// ErrGateFailure means the result failed a check such as --expect-file
+	ErrGateFailure = errors.New("check failed")
+	// ErrOutput means the result couldn't be rendered
+	ErrOutput = errors.New("output failed")
+)
+
+// markedError attaches an error category to err without changing its message
+type markedError struct {
+	kind error
+	err  error
+}
+
+func markError(kind, err error) error {
+	return &markedError{kind: kind, err: err}
+}
+
+func (e *markedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *markedError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// exitCodeForError maps an error to the exit code of its category
+func exitCodeForError(err error) int {
+	switch {
+	case errors.Is(err, ErrGitOperation):
+		return ExitGitFailure
+	case errors.Is(err, ErrFileNotFound), errors.Is(err, ErrRevisionNotFound):
+		return ExitExtractionFailure
+	case errors.Is(err, ErrGateFailure):
+		return ExitGateFailure
+	case errors.Is(err, ErrOutput):
+		return ExitOutputFailure
+	default:
+		return ExitUsage
+	}
+}
+
+// GitCommandError is returned when a git command fails or times out. It
+// matches ErrGitOperation.
+type GitCommandError struct {
+	// Args are the arguments git was run with
+	Args []string
+	// ExitCode is the exit code of git, -1 if it didn't exit on its own,
+	// e.g. because it timed out or couldn't be started
+	ExitCode int
+	// Stderr is what git printed on stderr, trimmed
+	Stderr string
+	Err    error
+}
+
+func (e *GitCommandError) Error() string {
+	if e.Stderr != "" {
+		return fmt.Sprintf("git %s: %v: %s", strings.Join(e.Args, " "), e.Err, e.Stderr)
+	}
+	return fmt.Sprintf("git %s: %v", strings.Join(e.Args, " "), e.Err)
+}
+
+func (e *GitCommandError) Unwrap() []error {
+	return []error{ErrGitOperation, e.Err}
+}
+
+// ExtractionError is returned when the revision can't be extracted from the
+// content of the revision file. It matches the category of Err, usually
+// ErrRevisionNotFound.
+type ExtractionError struct {
+	FilePath string
+	VarName  string
+	// Ref is the revision the file was read at, empty for the working tree
+	Ref string
+	Err error
+}
+
+func (e *ExtractionError) Error() string {
+	if e.Ref != "" {
+		return fmt.Sprintf("%v in '%s' at %s", e.Err, e.FilePath, e.Ref)
+	}
+	return fmt.Sprintf("%v in '%s'", e.Err, e.FilePath)
+}
+
+func (e *ExtractionError) Unwrap() error {
+	return e.Err
+}
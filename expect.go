@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// checkExpected compares the printed environments with the ones in the
+// --expect-file golden file and returns an ErrGateFailure error holding a
+// unified diff if they differ. Only the environments are compared, so meta
+// information such as git commands or deployed revisions may change freely.
+func checkExpected(result map[string][]CommitInfo, expectPath string) error {
+	expected, err := loadSnapshot(expectPath)
+	if err != nil {
+		return err
+	}
+
+	expectedJSON, err := json.MarshalIndent(envMap[[]CommitInfo](expected), "", "  ")
+	if err != nil {
+		return markError(ErrOutput, fmt.Errorf("failed to marshal JSON: %v", err))
+	}
+	actualJSON, err := json.MarshalIndent(envMap[[]CommitInfo](result), "", "  ")
+	if err != nil {
+		return markError(ErrOutput, fmt.Errorf("failed to marshal JSON: %v", err))
+	}
+	if string(expectedJSON) == string(actualJSON) {
+		return nil
+	}
+
+	diff := unifiedDiff(expectPath, "result", strings.Split(string(expectedJSON), "\n"), strings.Split(string(actualJSON), "\n"))
+	return markError(ErrGateFailure, fmt.Errorf("result differs from '%s':\n%s", expectPath, strings.TrimSuffix(diff, "\n")))
+}
+
+// unifiedDiff renders the differences between a and b as a unified diff.
+// The outputs compared here are small, so a plain LCS table is fine.
+func unifiedDiff(nameA, nameB string, a, b []string) string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	// ops holds every line of the diff marked with ' ', '-' or '+'
+	type op struct {
+		kind byte
+		line string
+		// posA and posB are the line indexes before this line in a and b
+		posA, posB int
+	}
+	var ops []op
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, op{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, op{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, op{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", nameA, nameB)
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+
+		// Extend the hunk while changes are at most two contexts apart
+		hunkStart := max(start-diffContext, 0)
+		end := start
+		for k := start; k < len(ops) && k-end <= 2*diffContext+1; k++ {
+			if ops[k].kind != ' ' {
+				end = k
+			}
+		}
+		hunkEnd := min(end+diffContext+1, len(ops))
+
+		countA, countB := 0, 0
+		for _, o := range ops[hunkStart:hunkEnd] {
+			if o.kind != '+' {
+				countA++
+			}
+			if o.kind != '-' {
+				countB++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", ops[hunkStart].posA+1, countA, ops[hunkStart].posB+1, countB)
+		for _, o := range ops[hunkStart:hunkEnd] {
+			fmt.Fprintf(&sb, "%c%s\n", o.kind, o.line)
+		}
+		start = hunkEnd
+	}
+	return sb.String()
+}
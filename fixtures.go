@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// loadFixtureResults builds the result for the selected environments from a
+// fixture file instead of git. The fixture has the shape of the JSON output:
+// commits keyed by environment name, tip first, optionally wrapped with
+// --with-meta. The entries are treated like the ones read from git, so the
+// --days window, --no-tip, --include-branch and --commits-behind still apply.
+func loadFixtureResults(fixturePath string, selectedEnvs []string) (map[string][]CommitInfo, resultMeta, error) {
+	fixture, err := loadSnapshot(fixturePath)
+	if err != nil {
+		return nil, resultMeta{}, err
+	}
+
+	result := make(map[string][]CommitInfo)
+	meta := resultMeta{Environments: make(map[string]*envMeta)}
+
+	for _, eb := range allBranches {
+		envName := eb.Env
+		if !containsString(selectedEnvs, envName) {
+			continue
+		}
+
+		commits, ok := fixture[envName]
+		if !ok || len(commits) == 0 {
+			fmt.Fprintf(os.Stderr, "Error processing branch '%s': no commits for environment '%s' in fixture '%s'\n", eb.Branch, envName, fixturePath)
+			continue
+		}
+		if !fixedNow.IsZero() {
+			// Like processBranch, the tip is the newest entry at or before
+			// --now
+			for len(commits) > 0 {
+				commitTime, err := parseCommitDate(commits[0].CommitDate)
+				if err != nil || !commitTime.After(fixedNow) {
+					break
+				}
+				commits = commits[1:]
+			}
+			if len(commits) == 0 {
+				fmt.Fprintf(os.Stderr, "Error processing branch '%s': no commits for environment '%s' before %s in fixture '%s'\n", eb.Branch, envName, fixedNow.Format(time.RFC3339), fixturePath)
+				continue
+			}
+		}
+
+		envDays := daysForEnv(envName)
+		// Same date granularity as git log --since in getHistoricalCommits
+		now := currentTime()
+		year, month, day := now.AddDate(0, 0, -envDays).Date()
+		sinceDate := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+
+		envInfo := &envMeta{Branch: eb.Branch}
+		if isBranchPattern(eb.Branch) {
+			// The fixture records which branch the pattern resolved to, if
+			// anything
+			envInfo.BranchPattern = eb.Branch
+			envInfo.Branch = commits[0].Branch
+		}
+
+		commitInfos := []CommitInfo{}
+		windowCount := 0
+		for i, commit := range commits {
+			// Fixture dates are in the output format rather than the one
+			// read from git
+			commitTime, err := parseCommitDate(commit.CommitDate)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error converting date to UTC for branch '%s', commit '%s': %v\n", eb.Branch, commit.RepoRevision, err)
+				continue
+			}
+			utcDate := commitTime.UTC().Format(outputDateLayout)
+			inWindow := envDays > 0 && !commitTime.Before(sinceDate) && (fixedNow.IsZero() || !commitTime.After(fixedNow))
+			if inWindow {
+				windowCount++
+			}
+
+			if i > 0 || noTip {
+				// History entries are limited to the --days window. Saved
+				// outputs already leave the tip commit out of the history, and
+				// a later commit back to the tip revision is a separate entry
+				if !inWindow {
+					continue
+				}
+				commit.CommitsBehindHead = nil
+			} else if !behind {
+				commit.CommitsBehindHead = nil
+			}
+
+			commit.CommitDate = utcDate
+			commit.Branch = ""
+			if inclBranch {
+				commit.Branch = envInfo.Branch
+			}
+			commitInfos = append(commitInfos, commit)
+		}
+
+		result[envName] = commitInfos
+		if envDays > 0 {
+			envInfo.CommitCount = &windowCount
+		}
+		meta.Environments[envName] = envInfo
+	}
+
+	if deployedURLTmpl != "" {
+		checkDeployedRevisions(result, meta)
+	}
+	if targetRepo != "" {
+		warnings := compareTargetRevisions(result, meta)
+		printWarnings(warnings)
+		meta.Warnings = append(meta.Warnings, warnings...)
+	}
+
+	return result, meta, nil
+}
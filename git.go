@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runCtx is cancelled when the run is interrupted, which stops any git
+// command still in flight
+var runCtx = context.Background()
+
+// runGit runs git with args in the current directory and returns its stdout.
+// git fetch and ls-remote are limited by fetchTimeout, every other command by
+// gitTimeout.
+func runGit(args ...string) ([]byte, error) {
+	return runGitInput("", args...)
+}
+
+// runGitInput is runGit with input written to git's stdin, for the --batch
+// modes of git cat-file
+func runGitInput(input string, args ...string) ([]byte, error) {
+	// Waiting for a slot doesn't count towards the timeout
+	if gitSlots != nil {
+		select {
+		case gitSlots <- struct{}{}:
+			defer func() { <-gitSlots }()
+		case <-runCtx.Done():
+			return nil, &GitCommandError{Args: args, ExitCode: -1, Err: runCtx.Err()}
+		}
+	}
+
+	timeout := gitTimeout
+	if len(args) > 0 && (args[0] == "fetch" || args[0] == "ls-remote") {
+		timeout = fetchTimeout
+	}
+	if timeout <= 0 {
+		return runGitContext(runCtx, input, args...)
+	}
+
+	ctx, cancel := context.WithTimeout(runCtx, timeout)
+	defer cancel()
+
+	output, err := runGitContext(ctx, input, args...)
+	var gitErr *GitCommandError
+	if errors.As(err, &gitErr) && ctx.Err() == context.DeadlineExceeded {
+		gitErr.ExitCode = -1
+		gitErr.Err = fmt.Errorf("timed out after %s", timeout)
+	}
+	return output, err
+}
+
+// gitCommands collects the arguments of the git commands run while recording
+// is enabled, for --record-commands
+var (
+	gitCommandsMu sync.Mutex
+	gitCommands   [][]string
+	recording     bool
+)
+
+// recordGitCommands enables or disables recording and drops anything recorded
+// so far
+func recordGitCommands(enabled bool) {
+	gitCommandsMu.Lock()
+	defer gitCommandsMu.Unlock()
+	recording = enabled
+	gitCommands = nil
+}
+
+// takeRecordedGitCommands returns the commands recorded since the last call
+// in the order they were started
+func takeRecordedGitCommands() [][]string {
+	gitCommandsMu.Lock()
+	defer gitCommandsMu.Unlock()
+	commands := gitCommands
+	gitCommands = nil
+	return commands
+}
+
+// gitSlots bounds the number of git processes running at once across the
+// whole process, nil for no limit. Set with --max-parallel-git.
+var gitSlots chan struct{}
+
+// limitParallelGit allows at most n concurrent git processes started by runGit,
+// 0 for no limit
+func limitParallelGit(n int) {
+	gitSlots = nil
+	if n > 0 {
+		gitSlots = make(chan struct{}, n)
+	}
+}
+
+func runGitContext(ctx context.Context, input string, args ...string) ([]byte, error) {
+	gitCommandsMu.Lock()
+	if recording {
+		gitCommands = append(gitCommands, append([]string{"git"}, args...))
+	}
+	gitCommandsMu.Unlock()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	// Interrupt rather than kill git so it can clean up lock files
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = 5 * time.Second
+	if input != "" {
+		cmd.Stdin = strings.NewReader(input)
+	}
+
+	// Keep git's explanation of a failure for the error
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		gitErr := &GitCommandError{
+			Args:     args,
+			ExitCode: -1,
+			Stderr:   strings.TrimSpace(stderr.String()),
+			Err:      err,
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			gitErr.ExitCode = exitErr.ExitCode()
+		}
+		return output, gitErr
+	}
+	return output, nil
+}
+
+func isBareRepository() (bool, error) {
+	output, err := runGit("rev-parse", "--is-bare-repository")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(output)) == "true", nil
+}
+
+// pendingOperations maps the files git keeps while an operation is in
+// progress to the command that finishes or aborts it
+var pendingOperations = []struct {
+	path    string
+	name    string
+	command string
+}{
+	{"MERGE_HEAD", "merge", "git merge --continue or --abort"},
+	{"rebase-merge", "rebase", "git rebase --continue or --abort"},
+	{"rebase-apply", "rebase or am", "git rebase --continue or --abort, or git am --continue or --abort"},
+	{"CHERRY_PICK_HEAD", "cherry-pick", "git cherry-pick --continue or --abort"},
+	{"REVERT_HEAD", "revert", "git revert --continue or --abort"},
+}
+
+// checkRepositoryState makes sure the branches can be checked out, so a
+// repository in the middle of a merge or rebase fails upfront instead of on
+// the first checkout. Bare repositories and --ref-prefix check nothing out
+// and are always fine.
+func checkRepositoryState() error {
+	if bareRepo || noCheckout {
+		return nil
+	}
+
+	output, err := runGit("rev-parse", "--is-inside-work-tree")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(output)) != "true" {
+		return markError(ErrGitOperation, errors.New("not inside the working tree of a repository"))
+	}
+
+	for _, op := range pendingOperations {
+		// --git-path also resolves the paths of linked worktrees
+		output, err := runGit("rev-parse", "--git-path", op.path)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(strings.TrimSpace(string(output))); err == nil {
+			return markError(ErrGitOperation, fmt.Errorf("a %s is in progress, finish it with %s before running the check", op.name, op.command))
+		}
+	}
+	return nil
+}
+
+// getCurrentRef returns the name of the checked out branch, or the commit
+// hash if HEAD is detached
+func getCurrentRef() (string, error) {
+	output, err := runGit("symbolic-ref", "--quiet", "--short", "HEAD")
+	if err == nil {
+		return strings.TrimSpace(string(output)), nil
+	}
+	return getCurrentCommitHash()
+}
+
+// restoreRef checks out ref again after the branches have been processed. It
+// doesn't use runCtx so it still works after an interrupt.
+func restoreRef(ref string) error {
+	_, err := runGitContext(context.Background(), "", "checkout", ref)
+	return err
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// githubTimeout limits each request to the GitHub API
+const githubTimeout = 30 * time.Second
+
+// githubPageSize is the number of commits requested per page, the maximum the
+// API allows
+const githubPageSize = 100
+
+// githubClient reads the revision file of --github-repo through the GitHub
+// REST API instead of a local clone
+type githubClient struct {
+	client  *http.Client
+	baseURL string
+	repo    string
+	token   string
+}
+
+func newGitHubClient(repo string) (*githubClient, error) {
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("invalid --github-repo '%s', expected owner/name", repo)
+	}
+	return &githubClient{
+		client:  &http.Client{Timeout: githubTimeout},
+		baseURL: strings.TrimSuffix(githubAPIURL, "/"),
+		repo:    url.PathEscape(owner) + "/" + url.PathEscape(name),
+		token:   os.Getenv("GITHUB_TOKEN"),
+	}, nil
+}
+
+// githubCommit is the part of a commit returned by the commits API that is
+// used here
+type githubCommit struct {
+	SHA    string `json:"sha"`
+	Commit struct {
+		Committer struct {
+			Date string `json:"date"`
+		} `json:"committer"`
+	} `json:"commit"`
+	Parents []struct {
+		SHA string `json:"sha"`
+	} `json:"parents"`
+}
+
+// get requests endpoint relative to the repository and returns the body
+func (c *githubClient) get(endpoint string, query url.Values, accept string) ([]byte, error) {
+	requestURL := c.baseURL + "/repos/" + c.repo + "/" + endpoint
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(runCtx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %v", requestURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+			return nil, fmt.Errorf("GET %s returned %s: %s", requestURL, resp.Status, apiErr.Message)
+		}
+		return nil, fmt.Errorf("GET %s returned %s", requestURL, resp.Status)
+	}
+	return body, nil
+}
+
+// fileContent returns the content of filePath at ref
+func (c *githubClient) fileContent(ref, filePath string) (string, error) {
+	body, err := c.get("contents/"+escapePath(filePath), url.Values{"ref": {ref}}, "application/vnd.github.raw")
+	if err != nil {
+		return "", fmt.Errorf("failed to read '%s' at %s: %w", filePath, ref, err)
+	}
+	return string(body), nil
+}
+
+// commits lists the commits on ref that changed filePath, newest first.
+// since and until are left out of the request if zero, and only the first
+// page is requested if limit is 1.
+func (c *githubClient) commits(ref, filePath string, since, until time.Time, limit int) ([]githubCommit, error) {
+	pageSize := githubPageSize
+	if limit == 1 {
+		pageSize = 1
+	}
+
+	var commits []githubCommit
+	for page := 1; ; page++ {
+		query := url.Values{
+			"sha":      {ref},
+			"path":     {filePath},
+			"per_page": {fmt.Sprint(pageSize)},
+			"page":     {fmt.Sprint(page)},
+		}
+		if !since.IsZero() {
+			query.Set("since", since.UTC().Format(time.RFC3339))
+		}
+		if !until.IsZero() {
+			query.Set("until", until.UTC().Format(time.RFC3339))
+		}
+
+		body, err := c.get("commits", query, "application/vnd.github+json")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list commits of '%s' on %s: %w", filePath, ref, err)
+		}
+		var pageCommits []githubCommit
+		if err := json.Unmarshal(body, &pageCommits); err != nil {
+			return nil, fmt.Errorf("failed to parse commits of '%s' on %s: %v", filePath, ref, err)
+		}
+
+		commits = append(commits, pageCommits...)
+		if len(pageCommits) < pageSize || limit == 1 {
+			return commits, nil
+		}
+	}
+}
+
+// escapePath escapes every element of a slash-separated path
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// processGitHubBranch is the counterpart of processBranch for --github-repo.
+// It reads the tip revision and, with a --days window, the history of the
+// revision file through the API.
+func processGitHubBranch(c *githubClient, branch string, noTip bool, source revisionSource, history historyOptions) (*branchResult, error) {
+	ref := branch
+	if tag, ok := tagName(branch); ok {
+		ref = tag
+	}
+	// The API takes paths relative to the repository root
+	source.FilePath = strings.TrimPrefix(path.Clean(source.FilePath), "/")
+
+	// With --now the tip is the last change before it rather than the
+	// current one
+	var until time.Time
+	if history.AsOf {
+		until = history.Now
+	}
+	tipCommits, err := c.commits(ref, source.FilePath, time.Time{}, until, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(tipCommits) == 0 {
+		return nil, markError(ErrFileNotFound, fmt.Errorf("no commit changed '%s' on %s", source.FilePath, ref))
+	}
+	tipCommit := tipCommits[0]
+
+	content, err := c.fileContent(tipCommit.SHA, source.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	tipRevision, err := extractRevisionFromContent(content, source.FilePath, source.VarName)
+	if err != nil {
+		return nil, &ExtractionError{FilePath: source.FilePath, VarName: source.VarName, Ref: ref, Err: err}
+	}
+
+	res := &branchResult{}
+	if !noTip {
+		res.Commits = append(res.Commits, CommitInfo{
+			RepoRevision: tipRevision,
+			CommitDate:   tipCommit.Commit.Committer.Date,
+		})
+	}
+
+	if history.DaysBack == 0 {
+		return res, nil
+	}
+
+	// Same date granularity as git log --since in getHistoricalCommits
+	year, month, day := history.Now.AddDate(0, 0, -history.DaysBack).Date()
+	since := time.Date(year, month, day, 0, 0, 0, 0, history.Now.Location())
+
+	historyCommits, err := c.commits(ref, source.FilePath, since, until, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get historical commits: %w", err)
+	}
+
+	for _, commit := range historyCommits {
+		if history.ExcludeMerges && len(commit.Parents) > 1 {
+			continue
+		}
+		res.WindowCommitCount++
+		if commit.SHA == tipCommit.SHA && !noTip {
+			continue // Already the tip entry
+		}
+
+		content, err := c.fileContent(commit.SHA, source.FilePath)
+		var revision string
+		if err == nil {
+			revision, err = extractRevisionFromContent(content, source.FilePath, source.VarName)
+		}
+		if err != nil {
+			res.SkippedCommits = append(res.SkippedCommits, SkippedCommit{
+				CommitHash: commit.SHA,
+				CommitDate: commit.Commit.Committer.Date,
+				Reason:     err.Error(),
+			})
+			continue
+		}
+
+		res.Commits = append(res.Commits, CommitInfo{
+			RepoRevision: revision,
+			CommitDate:   commit.Commit.Committer.Date,
+		})
+	}
+
+	return res, nil
+}
+
+// runGitHub runs the check against --github-repo. There is no local
+// repository, so nothing is checked out or restored.
+func runGitHub(selectedEnvs []string, redactRe *regexp.Regexp) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	runCtx = ctx
+
+	if watchInt > 0 {
+		watch(selectedEnvs, redactRe)
+		return
+	}
+
+	result, meta, failure := collectResults(selectedEnvs)
+	if ctx.Err() != nil {
+		os.Exit(ExitInterrupted)
+	}
+
+	finishRun(result, meta, redactRe, selectedEnvs, failure)
+}
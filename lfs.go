@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// lfsPointerHeader is the first line of the pointer file git-lfs stores in
+// the repository in place of the actual content
+const lfsPointerHeader = "version https://git-lfs.github.com/spec/v1"
+
+// errLFSPointer is returned when only the pointer of a file tracked with
+// git-lfs could be read, whose content would otherwise be parsed as garbage
+var errLFSPointer = errors.New("content is a Git LFS pointer, the LFS object wasn't fetched (install git-lfs and run git lfs pull)")
+
+func isLFSPointer(content string) bool {
+	return strings.HasPrefix(content, lfsPointerHeader+"\n")
+}
+
+// smudgeLFSPointer reads the version of filePath at ref through the filters
+// configured for it, which replaces an LFS pointer with the content if
+// git-lfs is installed and the object is available. Without git-lfs the
+// pointer is returned unchanged.
+func smudgeLFSPointer(ref, filePath string) (string, error) {
+	output, err := runGit("cat-file", "--filters", ref+":"+filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the Git LFS content of '%s' at %s: %w", filePath, ref, err)
+	}
+	return string(output), nil
+}
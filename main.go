@@ -1,64 +1,327 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
+// Exit codes used by the CLI so scripts can tell failure categories apart
+const (
+	// ExitUsage is returned for invalid arguments, flags or input files
+	ExitUsage = 1
+	// ExitGitFailure is returned when a required git operation failed
+	ExitGitFailure = 2
+	// ExitExtractionFailure is returned when a required revision couldn't be
+	// extracted from the revision file
+	ExitExtractionFailure = 3
+	// ExitGateFailure is returned when a drift or staleness check failed
+	ExitGateFailure = 4
+	// ExitOutputFailure is returned when the result couldn't be rendered
+	ExitOutputFailure = 5
+	// ExitInterrupted is returned when the run was stopped by SIGINT/SIGTERM
+	ExitInterrupted = 130
+)
+
+// minWatchInterval keeps --watch from hammering the git server unless
+// --allow-fast-polling is given
+const minWatchInterval = 5 * time.Second
+
 type CommitInfo struct {
-	RepoRevision string `json:"repo_revision"`
-	CommitDate   string `json:"commit_date"`
+	RepoRevision string `json:"repo_revision" toml:"repo_revision"`
+	CommitDate   string `json:"commit_date" toml:"commit_date"`
+	// CommitsBehindHead is only set on the tip entry with --commits-behind
+	CommitsBehindHead *int `json:"commits_behind_head,omitempty" toml:"commits_behind_head,omitempty"`
+	// Branch is the branch the commit was read from, set with --include-branch
+	Branch string `json:"branch,omitempty" toml:"branch,omitempty"`
+	// Tag is the tag the revision was read at, set with --tag-history on
+	// environments pinned to a tag
+	Tag string `json:"tag,omitempty" toml:"tag,omitempty"`
+	// SignatureVerified tells whether the commit has a good GPG signature
+	// from a trusted key and Signer is the name on the signature, set with
+	// --verify-signature
+	SignatureVerified *bool  `json:"signature_verified,omitempty" toml:"signature_verified,omitempty"`
+	Signer            string `json:"signer,omitempty" toml:"signer,omitempty"`
+	// Status is CommitStatusUncommitted for the working tree entry of
+	// --include-worktree and empty for commits
+	Status string `json:"status,omitempty" toml:"status,omitempty"`
+}
+
+// envMeta holds additional per-environment information printed with --with-meta
+type envMeta struct {
+	// Branch is the branch that was read. BranchPattern is the pattern it was
+	// resolved from, if the mapping was a pattern.
+	Branch        string `json:"branch,omitempty"`
+	BranchPattern string `json:"branch_pattern,omitempty"`
+	// Ref is the commit-ish the environment was pinned to with --ref, read
+	// instead of the branch
+	Ref string `json:"ref,omitempty"`
+	// StaleRelativeToRemote is only set in quick mode when the remote-tracking
+	// branch is known
+	StaleRelativeToRemote *bool `json:"stale_relative_to_remote,omitempty"`
+	// Pushed is only set in quick mode and tells whether the last change to
+	// the revision file is reachable from the remote-tracking branch
+	Pushed *bool `json:"pushed,omitempty"`
+	// CommitCount is the number of revision file changes within the --days
+	// window
+	CommitCount *int `json:"commit_count,omitempty"`
+	// SkippedCommits lists the history commits left out because the revision
+	// couldn't be read, set with --include-errors
+	SkippedCommits []SkippedCommit `json:"skipped_commits,omitempty"`
+	// GitCommands holds the arguments of every git command run for the
+	// environment, set with --record-commands
+	GitCommands [][]string `json:"git_commands,omitempty"`
+	// DeployedRevision is the revision reported by --deployed-url-template
+	// and DeployedMatch whether it matches the tip revision
+	DeployedRevision string `json:"deployed_revision,omitempty"`
+	DeployedMatch    *bool  `json:"deployed_match,omitempty"`
+	// TargetAhead and TargetBehind are the number of commits the tip
+	// revision is ahead of and behind the baseline environment's tip
+	// revision in --target-repo
+	TargetAhead  *int `json:"target_commits_ahead,omitempty"`
+	TargetBehind *int `json:"target_commits_behind,omitempty"`
+}
+
+type resultMeta struct {
+	// RepoName labels the output with the repository it was read from, set
+	// with --repo-name
+	RepoName     string           `json:"repo_name,omitempty"`
+	Environments envMap[*envMeta] `json:"environments"`
+	// Histogram counts the environments by tip commit age, set with
+	// --histogram
+	Histogram []ageBucket `json:"histogram,omitempty"`
+	// ResultHash is printed next to the environments rather than in meta
+	ResultHash string `json:"-"`
+	// Checksum is the SHA-256 of the canonical JSON output without the
+	// checksum itself, set with --with-checksum
+	Checksum string `json:"checksum,omitempty"`
+	// Warnings are the problems of the run that didn't stop it, always
+	// collected but only printed with --include-warnings
+	Warnings []Warning `json:"warnings,omitempty"`
+}
+
+// resultWithMeta is the JSON output shape used with --with-meta
+type resultWithMeta struct {
+	Environments envMap[[]outputCommit] `json:"environments"`
+	Meta         resultMeta             `json:"meta"`
+	// ResultHash is the SHA-256 of the environments, set with --include-hash
+	ResultHash string `json:"result_hash,omitempty"`
 }
 
 var (
 	quickMode bool
 	envList   string
 	days      int
+	daysSpec  string
+	noMerges  bool
+	follow    bool
+	outFormat string
+	redactPat string
+	revFile   string
+	varName   string
+	showWork  int
+	watchInt  time.Duration
+	withMeta  bool
+	noStale   bool
+	behind    bool
+
+	gitTimeout   time.Duration
+	fetchTimeout time.Duration
+	branchMaps   []string
+	onlyChanged  bool
+	onlyNew      bool
+	baselineEnv  string
+	outputFile   string
+	stdoutFmt    string
+	exclBranches []string
+	exclEnvList  string
+	inclBranch   bool
+	fixtureFile  string
+	verbose      bool
+	inclErrors   bool
+	recordCmds   bool
+	histogram    bool
+	stateFile    string
+	noTip        bool
+
+	firstRunChanged bool
+	nowSpec         string
+	includeHash     bool
+	allowFastPoll   bool
+	templateText    string
+	templateFile    string
+	configFile      string
+	deployedURLTmpl string
+	targetRepo      string
+	deployedField   string
+	onChangeCmd     string
+	githubRepo      string
+	githubAPIURL    string
+	maxParallelGit  int
+	remoteName      string
+	envRemoteMaps   []string
+	envRefMaps      []string
+	branchPrefix    string
+	caseInsensitive bool
+	withChecksum    bool
+	keyRevision     string
+	keyDate         string
+	expectFile      string
+	validateSchema  string
+	strictJSON      bool
+	repoName        string
+	allowLocal      bool
+	tagHistory      string
+	failIfMissing   bool
+	relativeDates   bool
+	branchesOnly    bool
+	verifySig       bool
+	configHeaders   []string
+	reproducible    bool
+	inclWarnings    bool
+	noFollowLinks   bool
+	refPrefix       string
+	inclWorktree    bool
+	configCacheTTL  time.Duration
+	checksumFile    string
+	// envDays holds the per-environment --days overrides
+	envDays map[string]int
+	// fixedNow is the time set with --now, zero for the real time
+	fixedNow time.Time
 )
 
+// ghClient reads the branches through the GitHub API with --github-repo
+var ghClient *githubClient
+
+// bareRepo is set when the repository has no working tree, in which case
+// branches are read through git objects instead of being checked out
+var bareRepo bool
+
+// noCheckout is set by --ref-prefix, in which case branches are read from
+// their remote-tracking refs and the working tree is left alone
+var noCheckout bool
+
 var rootCmd = &cobra.Command{
 	Use:   "repo-rev-checker [directory]",
 	Short: "Check repository revisions across different branches",
 	Long: `A tool that pulls the latest changes from main, release/hcp/public/stg and release/hcp/public/prod branches,
 extracts ARO_HCP_REPO_REVISION values from ./hcp/Revision.mk and outputs them as JSON.`,
-	Args: cobra.ExactArgs(1),
+	Args: cobra.MaximumNArgs(1),
 	Run:  runCommand,
 }
 
 func init() {
 	rootCmd.Flags().BoolVarP(&quickMode, "quick", "q", false, "Skip git fetch/reset operations and use repository as-is")
+	rootCmd.Flags().StringVar(&configFile, "config", "", "YAML file or http(s) URL configuring environments (name, branch, var_name)")
+	rootCmd.Flags().StringArrayVar(&configHeaders, "config-header", nil, "Header sent when fetching a --config URL as 'Name: value', e.g. for authentication. Can be repeated.")
+	rootCmd.Flags().DurationVar(&configCacheTTL, "config-cache-ttl", time.Hour, "Use the cached copy of a --config URL if fetching it fails and the copy isn't older than this. 0 disables the cache.")
 	rootCmd.Flags().StringVarP(&envList, "envs", "e", "", "Comma-separated list of environments to analyze (int,stg,prod). If not specified, all environments are processed.")
-	rootCmd.Flags().IntVarP(&days, "days", "d", 0, "Number of days to look back in commit history for Revision.mk changes. If 0, only checks the tip commit.")
+	rootCmd.Flags().StringArrayVar(&branchMaps, "branch", nil, "Map an environment to a branch as env=branch, overriding the default or adding a new environment. The branch may be a glob pattern such as release/hcp/public/prod-*, which selects the most recently committed matching remote branch. Can be repeated.")
+	rootCmd.Flags().StringVar(&remoteName, "remote", "origin", "Remote the branches are fetched from and reset to")
+	rootCmd.Flags().StringVar(&refPrefix, "ref-prefix", "origin/", "Read each branch from refs/remotes/<ref-prefix><branch> without checking anything out. Only used if given.")
+	rootCmd.Flags().BoolVar(&allowLocal, "allow-local", false, "Read the local branch as-is when the remote has no such branch instead of failing")
+	rootCmd.Flags().StringArrayVar(&envRemoteMaps, "env-remote", nil, "Use a different remote for an environment as env=remote, overriding --remote. Can be repeated.")
+	rootCmd.Flags().StringArrayVar(&envRefMaps, "ref", nil, "Read an environment at a commit-ish as env=commitish, e.g. prod=<sha>, instead of at the tip of its branch. Can be repeated.")
+	rootCmd.Flags().StringVar(&branchPrefix, "branch-prefix", "", "Discover additional environments from the remote branches starting with this prefix, e.g. release/hcp/public/, named after the final path segment of the branch")
+	rootCmd.Flags().StringVar(&exclEnvList, "exclude-env", "", "Comma-separated list of environments to leave out of the ones selected with --envs or by default")
+	rootCmd.Flags().StringArrayVar(&exclBranches, "exclude-branch", nil, "Don't process the environment mapped to this branch (as given by the default mapping or --branch). Can be repeated.")
+	rootCmd.Flags().StringVarP(&daysSpec, "days", "d", "0", "Number of days to look back in commit history for Revision.mk changes. If 0, only checks the tip commit. Accepts per-environment overrides as env=days, e.g. 7,prod=90.")
+	rootCmd.Flags().StringVar(&nowSpec, "now", "", "Evaluate the --days window and commit ages as of this time (RFC 3339, e.g. 2025-01-31T12:00:00Z) instead of the current time")
+	rootCmd.Flags().BoolVar(&noTip, "no-tip", false, "With --days, only report the revision file changes within the window and leave out the tip commit if it is older")
+	rootCmd.Flags().StringVar(&revFile, "revision-file", "./hcp/Revision.mk", "Path of the revision file inside the repository. The extension selects the parser: .mk (Makefile), .yaml/.yml or .json")
+	rootCmd.Flags().StringVar(&varName, "var-name", "ARO_HCP_REPO_REVISION", "Variable (Makefile) or key (YAML/JSON, dot-separated for nested keys) holding the revision")
+	rootCmd.Flags().BoolVar(&caseInsensitive, "case-insensitive", false, "Match the Makefile variable of --var-name regardless of case")
+	rootCmd.Flags().BoolVar(&noMerges, "no-merges", false, "Exclude merge commits from the commit history (only direct edits to Revision.mk are reported)")
+	rootCmd.Flags().StringVar(&tagHistory, "tag-history", "", "For environments pinned to a tag, report the tags matching this pattern, e.g. 'v*', up to the pinned one sorted by version as history instead of the commits of --days")
+	rootCmd.Flags().BoolVar(&noFollowLinks, "no-follow-symlinks", false, "Read a revision file that is a symlink as the link itself instead of the file it points to, in the working tree and in history")
+	rootCmd.Flags().BoolVar(&follow, "follow", false, "Follow renames of Revision.mk when looking through commit history")
+	rootCmd.Flags().StringVar(&redactPat, "redact-pattern", "", "Regular expression; parts of revision values matching it are replaced with *** in the output")
+	rootCmd.Flags().IntVar(&showWork, "show-workers", 4, "Maximum number of concurrent batches of git calls when reading commit history")
+	rootCmd.Flags().StringVarP(&outFormat, "format", "f", "json", "Output format (json, jsonl, env, gitlab, table, prometheus, toml, template, diff-only, csv-wide). The env, gitlab and prometheus formats only include each environment's tip commit.")
+	rootCmd.Flags().StringVar(&keyRevision, "key-revision", "repo_revision", "Name of the revision field in the json and jsonl formats")
+	rootCmd.Flags().StringVar(&keyDate, "key-date", "commit_date", "Name of the commit date field in the json and jsonl formats")
+	rootCmd.Flags().StringVar(&templateText, "template", "", "Go text/template rendered by the template format")
+	rootCmd.Flags().StringVar(&templateFile, "template-file", "", "File holding the Go text/template rendered by the template format")
+	rootCmd.Flags().IntVar(&maxParallelGit, "max-parallel-git", 0, "Maximum number of git processes running at the same time across all branches, on top of --show-workers. 0 means no limit.")
+	rootCmd.Flags().BoolVar(&behind, "commits-behind", false, "Report how many commits each branch HEAD is ahead of the last revision file change (commits_behind_head on the tip entry)")
+	rootCmd.Flags().BoolVar(&inclWorktree, "include-worktree", false, "With --quick, add the uncommitted revision in the working tree of the checked out branch as an entry with status uncommitted if it differs from the committed one")
+	rootCmd.Flags().BoolVar(&inclBranch, "include-branch", false, "Add the branch each commit was read from to every entry")
+	rootCmd.Flags().BoolVar(&verbose, "verbose", false, "Report history commits that were skipped because the revision couldn't be read on stderr")
+	rootCmd.Flags().BoolVar(&inclWarnings, "include-warnings", false, "Add the problems of the run, such as failed branches and skipped commits, to the meta output as warnings (implies --with-meta)")
+	rootCmd.Flags().BoolVar(&inclErrors, "include-errors", false, "Add the history commits that were skipped because the revision couldn't be read to the meta output (implies --with-meta)")
+	rootCmd.Flags().BoolVar(&recordCmds, "record-commands", false, "Add the git commands run for each environment to the meta output (implies --with-meta)")
+	rootCmd.Flags().BoolVar(&histogram, "histogram", false, "Add a summary counting the environments by tip commit age (<1d, 1-7d, 7-30d, >30d) to the meta output (implies --with-meta) and the table format")
+	rootCmd.Flags().BoolVar(&includeHash, "include-hash", false, "Add a SHA-256 hash of the environments as result_hash to the JSON output for cheap change detection (implies --with-meta)")
+	rootCmd.Flags().BoolVar(&withChecksum, "with-checksum", false, "Add a SHA-256 checksum of the canonical JSON output to the meta output for integrity checks (implies --with-meta)")
+	rootCmd.Flags().StringVar(&checksumFile, "checksum-file", "", "Write the --with-checksum checksum to this file (implies --with-checksum)")
+	rootCmd.Flags().StringVar(&deployedURLTmpl, "deployed-url-template", "", "URL returning the deployed revision of an environment as JSON, with {env} replaced by the environment name. Adds deployed_revision and deployed_match to the meta output (implies --with-meta).")
+	rootCmd.Flags().StringVar(&deployedField, "deployed-field", "revision", "Key of the deployed revision in the --deployed-url-template response, dot-separated for nested keys")
+	rootCmd.Flags().BoolVar(&onlyChanged, "only-changed", false, "Only output environments whose tip revision differs from the one of --baseline-env")
+	rootCmd.Flags().BoolVar(&onlyNew, "only-changed-since-last-run", false, "Only output environments whose tip revision differs from the one stored in --state-file by the previous run. Everything is output if there is no state file yet.")
+	rootCmd.Flags().StringVar(&baselineEnv, "baseline-env", "prod", "Environment that --only-changed and --target-repo compare against")
+	rootCmd.Flags().StringVar(&targetRepo, "target-repo", "", "Clone of the repository the revisions point into. Adds the number of commits each environment's revision is ahead of and behind the --baseline-env revision there to the meta output (implies --with-meta).")
+	rootCmd.Flags().BoolVar(&withMeta, "with-meta", false, "Wrap the JSON output as {\"environments\": ..., \"meta\": ...} with additional per-environment information")
+	rootCmd.Flags().BoolVar(&noStale, "no-stale-warning", false, "Don't warn when a local branch differs from its remote-tracking branch or has unpushed revision file changes in quick mode")
+	rootCmd.Flags().DurationVar(&gitTimeout, "timeout", 0, "Maximum duration of each local git command (checkout, log, show, ...), e.g. 30s. 0 means no limit.")
+	rootCmd.Flags().DurationVar(&fetchTimeout, "fetch-timeout", 0, "Maximum duration of git fetch, e.g. 5m. 0 means no limit.")
+	rootCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Write the result in --format to this file instead of stdout")
+	rootCmd.Flags().StringVar(&stdoutFmt, "stdout-format", "", "Format printed to stdout. With --output this prints a second rendering of the same result, e.g. a table on the terminal next to a JSON file.")
+	rootCmd.Flags().StringVar(&githubRepo, "github-repo", "", "Read the revision file of each branch from this GitHub repository (owner/name) through the REST API instead of a local clone. Uses the token in GITHUB_TOKEN if set. No repository directory is needed.")
+	rootCmd.Flags().StringVar(&githubAPIURL, "github-api-url", "https://api.github.com", "Base URL of the GitHub REST API used with --github-repo, e.g. for GitHub Enterprise Server")
+	rootCmd.Flags().StringVar(&fixtureFile, "fixtures", "", "Read the commits of each environment from this JSON file (in the JSON output format) instead of git. No repository directory is needed.")
+	rootCmd.Flags().BoolVar(&verifySig, "verify-signature", false, "Add signature_verified and signer to every commit, telling whether the commit that set the revision has a good GPG signature from a trusted key")
+	rootCmd.Flags().BoolVar(&branchesOnly, "branch-exists-only", false, "Only report whether the branch of each selected environment exists on its remote, as a JSON object of environment to true/false, without fetching or reading anything")
+	rootCmd.Flags().BoolVar(&reproducible, "reproducible", false, "Leave out everything that depends on the time of the run (--histogram, --relative-time, template ages) so the output only changes with the repository")
+	rootCmd.Flags().BoolVar(&relativeDates, "relative-time", false, "Show commit dates in the table format relative to now, e.g. \"3 days ago\", followed by the absolute date")
+	rootCmd.Flags().BoolVar(&failIfMissing, "fail-if-missing", false, "Exit with code 4 if any selected environment has no revision, e.g. because its branch or revision file is missing")
+	rootCmd.Flags().StringVar(&repoName, "repo-name", "", "Label for the repository, added to the meta output as repo_name (implies --with-meta), to every jsonl line and prometheus sample and to the environment of --on-change-cmd, to tell apart the outputs of several repositories")
+	rootCmd.Flags().BoolVar(&strictJSON, "strict-json", false, "Parse the JSON output back before printing anything and fail the run if a revision doesn't consist of a letter or digit followed by letters, digits and . _ / + -")
+	rootCmd.Flags().StringVar(&validateSchema, "validate-output", "", "Validate the json or jsonl output against this JSON Schema file before printing it and fail if it doesn't match")
+	rootCmd.Flags().StringVar(&expectFile, "expect-file", "", "Compare the environments of the result with this JSON file (in the JSON output format) and exit with code 4 and a unified diff on stderr if they differ")
+	rootCmd.Flags().StringVar(&stateFile, "state-file", "", "JSON file storing the tip revision of each environment between runs, updated after every run")
+	rootCmd.Flags().BoolVar(&firstRunChanged, "first-run-changed", false, "Treat every environment as new when --state-file doesn't exist yet, instead of only recording the revisions")
+	rootCmd.Flags().StringVar(&onChangeCmd, "on-change-cmd", "", "Shell command run for every environment whose tip revision changed since the run that wrote --state-file, with RRC_ENV, RRC_OLD_REVISION and RRC_NEW_REVISION set")
+	rootCmd.Flags().DurationVar(&watchInt, "watch", 0, "Keep running and re-check the branches on this interval (e.g. 30s, 5m) until interrupted. The interval is counted from the end of the previous check.")
+	rootCmd.Flags().BoolVar(&allowFastPoll, "allow-fast-polling", false, fmt.Sprintf("Allow --watch intervals below %s", minWatchInterval))
 }
 
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(ExitUsage)
 	}
 }
 
 func parseEnvironments(envStr string) ([]string, error) {
+	var allEnvNames []string
+	validEnvNames := make(map[string]bool)
+	for _, eb := range allBranches {
+		allEnvNames = append(allEnvNames, eb.Env)
+		validEnvNames[eb.Env] = true
+	}
+
 	if envStr == "" {
 		// Default to all environments
-		return []string{"int", "stg", "prod"}, nil
+		return allEnvNames, nil
 	}
 
 	// Split by comma and trim spaces
 	envs := strings.Split(envStr, ",")
 	var validEnvs []string
-	validEnvNames := map[string]bool{
-		"int":  true,
-		"stg":  true,
-		"prod": true,
-	}
 
 	for _, env := range envs {
 		env = strings.TrimSpace(env)
@@ -66,7 +329,11 @@ func parseEnvironments(envStr string) ([]string, error) {
 			continue
 		}
 		if !validEnvNames[env] {
-			return nil, fmt.Errorf("invalid environment '%s'. Valid environments are: int, stg, prod", env)
+			return nil, fmt.Errorf("invalid environment '%s'. Valid environments are: %s", env, strings.Join(allEnvNames, ", "))
+		}
+		if containsString(validEnvs, env) {
+			// e.g. an environment given both by name and by alias
+			continue
 		}
 		validEnvs = append(validEnvs, env)
 	}
@@ -78,44 +345,522 @@ func parseEnvironments(envStr string) ([]string, error) {
 	return validEnvs, nil
 }
 
+// excludeEnvironments removes the comma-separated environments of excludeStr
+// from selectedEnvs. The names are validated like with parseEnvironments, so a
+// typo fails instead of silently excluding nothing.
+func excludeEnvironments(selectedEnvs []string, excludeStr string) ([]string, error) {
+	if excludeStr == "" {
+		return selectedEnvs, nil
+	}
+	excluded, err := parseEnvironments(excludeStr)
+	if err != nil {
+		return nil, fmt.Errorf("--exclude-env: %v", err)
+	}
+
+	var remaining []string
+	for _, env := range selectedEnvs {
+		if !containsString(excluded, env) {
+			remaining = append(remaining, env)
+		}
+	}
+	if len(remaining) == 0 {
+		return nil, fmt.Errorf("no environments left to process after --exclude-env")
+	}
+	return remaining, nil
+}
+
+// parseDays parses --days as a comma-separated list of a default number of
+// days and env=days overrides, e.g. "7,prod=90"
+func parseDays(spec string) (int, map[string]int, error) {
+	defaultDays := 0
+	overrides := make(map[string]int)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		envName, value, isOverride := strings.Cut(part, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if !isOverride {
+			n, err = strconv.Atoi(part)
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid --days value '%s', expected a number of days or env=days", part)
+		}
+
+		if isOverride {
+			overrides[strings.TrimSpace(envName)] = n
+		} else {
+			defaultDays = n
+		}
+	}
+	return defaultDays, overrides, nil
+}
+
+// daysForEnv returns the --days window of an environment
+func daysForEnv(envName string) int {
+	if n, ok := envDays[envName]; ok {
+		return n
+	}
+	return days
+}
+
+// currentTime returns --now if it was given, the real time otherwise
+func currentTime() time.Time {
+	if !fixedNow.IsZero() {
+		return fixedNow
+	}
+	return time.Now()
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateNumericFlags rejects negative values, which would otherwise lead to
+// confusing results such as a --days window starting in the future
+func validateNumericFlags() error {
+	if days < 0 {
+		return fmt.Errorf("--days must not be negative, got %d", days)
+	}
+	for envName, n := range envDays {
+		if n < 0 {
+			return fmt.Errorf("--days must not be negative, got %d for '%s'", n, envName)
+		}
+	}
+	if showWork < 0 {
+		return fmt.Errorf("--show-workers must not be negative, got %d", showWork)
+	}
+	if maxParallelGit < 0 {
+		return fmt.Errorf("--max-parallel-git must not be negative, got %d", maxParallelGit)
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"--timeout", gitTimeout},
+		{"--fetch-timeout", fetchTimeout},
+		{"--watch", watchInt},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %s", d.name, d.value)
+		}
+	}
+
+	return nil
+}
+
 func runCommand(cmd *cobra.Command, args []string) {
-	directory := args[0]
+	if len(args) == 0 && fixtureFile == "" && githubRepo == "" {
+		fmt.Fprintf(os.Stderr, "Error: a repository directory is required unless --fixtures or --github-repo is given\n")
+		os.Exit(ExitUsage)
+	}
+	if githubRepo != "" && (len(args) > 0 || fixtureFile != "") {
+		fmt.Fprintf(os.Stderr, "Error: --github-repo can't be used with a repository directory or --fixtures\n")
+		os.Exit(ExitUsage)
+	}
+	if branchesOnly && (githubRepo != "" || fixtureFile != "" || watchInt > 0) {
+		fmt.Fprintf(os.Stderr, "Error: --branch-exists-only can't be used with --github-repo, --fixtures or --watch\n")
+		os.Exit(ExitUsage)
+	}
+	noCheckout = cmd.Flags().Changed("ref-prefix")
+	if noCheckout && (githubRepo != "" || refPrefix == "") {
+		fmt.Fprintf(os.Stderr, "Error: --ref-prefix must not be empty and can't be used with --github-repo\n")
+		os.Exit(ExitUsage)
+	}
+	if len(envRefMaps) > 0 && (githubRepo != "" || fixtureFile != "") {
+		fmt.Fprintf(os.Stderr, "Error: --ref can't be used with --github-repo or --fixtures\n")
+		os.Exit(ExitUsage)
+	}
+	if githubRepo != "" && (follow || behind || tagHistory != "" || verifySig) {
+		fmt.Fprintf(os.Stderr, "Error: --follow, --commits-behind, --tag-history and --verify-signature aren't supported with --github-repo\n")
+		os.Exit(ExitUsage)
+	}
+	if watchInt > 0 && fixtureFile != "" {
+		fmt.Fprintf(os.Stderr, "Error: --watch can't be used with --fixtures\n")
+		os.Exit(ExitUsage)
+	}
+
+	var err error
+	if configFile != "" {
+		cfg, err := loadConfig(configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+		if err := applyConfig(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+		resolveEnvAliases()
+	}
+
+	allBranches, err = applyBranchMappings(allBranches, branchMaps)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+
+	if branchPrefix != "" {
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: --branch-prefix needs a repository directory to discover branches in\n")
+			os.Exit(ExitUsage)
+		}
+		discovered, err := discoverBranches(args[0], remoteName, branchPrefix, quickMode)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitCodeForError(err))
+		}
+		allBranches = addDiscoveredBranches(allBranches, discovered)
+	}
+
+	if err := applyEnvRemotes(envRemoteMaps); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+	if err := applyEnvRefs(envRefMaps); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitUsage)
+	}
 
 	// Parse and validate environments
 	selectedEnvs, err := parseEnvironments(envList)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(ExitUsage)
+	}
+	selectedEnvs, err = excludeEnvironments(selectedEnvs, exclEnvList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+
+	days, envDays, err = parseDays(daysSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+
+	if nowSpec != "" {
+		fixedNow, err = time.Parse(time.RFC3339, nowSpec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --now '%s', expected RFC 3339 such as 2025-01-31T12:00:00Z\n", nowSpec)
+			os.Exit(ExitUsage)
+		}
+	}
+
+	if err := validateNumericFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+
+	limitParallelGit(maxParallelGit)
+
+	if watchInt > 0 && watchInt < minWatchInterval && !allowFastPoll {
+		fmt.Fprintf(os.Stderr, "Error: --watch interval %s is below the minimum of %s, use --allow-fast-polling to allow it\n", watchInt, minWatchInterval)
+		os.Exit(ExitUsage)
+	}
+
+	for _, format := range []string{outFormat, stdoutFmt} {
+		if format != "" && !validFormats[format] {
+			fmt.Fprintf(os.Stderr, "Error: invalid format '%s'. Valid formats are: json, jsonl, env, gitlab, table, prometheus, toml, template, diff-only, csv-wide\n", format)
+			os.Exit(ExitUsage)
+		}
+		if format == "template" && outputTemplate == nil {
+			outputTemplate, err = loadOutputTemplate(templateText, templateFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(ExitUsage)
+			}
+		}
+	}
+
+	if checksumFile != "" {
+		withChecksum = true
+		// The working directory changes to the repository below
+		checksumFile, err = filepath.Abs(checksumFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid checksum file path: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+	}
+	if err := validateOutputKeys(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+
+	if inclErrors || inclWarnings || recordCmds || histogram || includeHash || withChecksum || deployedURLTmpl != "" || targetRepo != "" || repoName != "" {
+		withMeta = true
+	}
+
+	if outputFile != "" {
+		// The working directory changes to the repository below
+		outputFile, err = filepath.Abs(outputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid output path: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+	}
+
+	if onChangeCmd != "" && stateFile == "" {
+		fmt.Fprintf(os.Stderr, "Error: --on-change-cmd requires --state-file to detect changes\n")
+		os.Exit(ExitUsage)
+	}
+	if onlyNew && stateFile == "" {
+		fmt.Fprintf(os.Stderr, "Error: --only-changed-since-last-run requires --state-file to detect changes\n")
+		os.Exit(ExitUsage)
+	}
+	if validateSchema != "" {
+		isJSON := func(format string) bool { return format == "json" || format == "jsonl" }
+		if !(outputFile != "" && isJSON(outFormat)) && !isJSON(stdoutFormat()) {
+			fmt.Fprintf(os.Stderr, "Error: --validate-output requires the json or jsonl format\n")
+			os.Exit(ExitUsage)
+		}
+		outputSchema, err = loadOutputSchema(validateSchema)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+	}
+	if expectFile != "" {
+		expectFile, err = filepath.Abs(expectFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid expect file path: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+	}
+	if targetRepo != "" {
+		targetRepo, err = filepath.Abs(targetRepo)
+		if err == nil {
+			_, err = runGit("-C", targetRepo, "rev-parse", "--git-dir")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --target-repo '%s' is not a git repository: %v\n", targetRepo, err)
+			os.Exit(ExitUsage)
+		}
+	}
+	if stateFile != "" {
+		stateFile, err = filepath.Abs(stateFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid state file path: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+	}
+
+	selectedEnvs = excludeBranches(selectedEnvs, exclBranches)
+	if len(selectedEnvs) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: no environments left to process after --exclude-branch\n")
+		os.Exit(ExitUsage)
+	}
+
+	if inclWorktree && (!quickMode || noTip || noCheckout || githubRepo != "" || fixtureFile != "") {
+		fmt.Fprintf(os.Stderr, "Error: --include-worktree requires --quick and can't be used with --no-tip, --ref-prefix, --github-repo or --fixtures\n")
+		os.Exit(ExitUsage)
+	}
+
+	if noTip && days == 0 && len(envDays) == 0 && tagHistory == "" {
+		fmt.Fprintf(os.Stderr, "Error: --no-tip requires --days or --tag-history\n")
+		os.Exit(ExitUsage)
+	}
+
+	for envName := range envDays {
+		if !containsString(selectedEnvs, envName) {
+			fmt.Fprintf(os.Stderr, "Error: --days override for '%s', which is not among the selected environments\n", envName)
+			os.Exit(ExitUsage)
+		}
+	}
+
+	if (onlyChanged || targetRepo != "") && !containsString(selectedEnvs, baselineEnv) {
+		fmt.Fprintf(os.Stderr, "Error: baseline environment '%s' is not among the selected environments\n", baselineEnv)
+		os.Exit(ExitUsage)
+	}
+
+	var redactRe *regexp.Regexp
+	if redactPat != "" {
+		redactRe, err = regexp.Compile(redactPat)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid redact pattern '%s': %v\n", redactPat, err)
+			os.Exit(ExitUsage)
+		}
 	}
 
+	if fixtureFile != "" {
+		// The fixture replaces the repository, so no git command is run
+		result, meta, err := loadFixtureResults(fixtureFile, selectedEnvs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+		finishRun(result, meta, redactRe, selectedEnvs, nil)
+		return
+	}
+
+	if githubRepo != "" {
+		ghClient, err = newGitHubClient(githubRepo)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+		for _, eb := range allBranches {
+			if containsString(selectedEnvs, eb.Env) && isBranchPattern(eb.Branch) {
+				fmt.Fprintf(os.Stderr, "Error: branch pattern '%s' isn't supported with --github-repo\n", eb.Branch)
+				os.Exit(ExitUsage)
+			}
+		}
+		runGitHub(selectedEnvs, redactRe)
+		return
+	}
+
+	directory := args[0]
+
 	// Check if directory exists
 	if _, err := os.Stat(directory); os.IsNotExist(err) {
 		fmt.Fprintf(os.Stderr, "Error: Directory '%s' does not exist\n", directory)
-		os.Exit(1)
+		os.Exit(ExitUsage)
 	}
 
 	// Change to the directory
 	originalDir, err := os.Getwd()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
-		os.Exit(1)
+		os.Exit(ExitUsage)
 	}
 
 	if err := os.Chdir(directory); err != nil {
 		fmt.Fprintf(os.Stderr, "Error changing to directory '%s': %v\n", directory, err)
-		os.Exit(1)
+		os.Exit(ExitUsage)
 	}
 	defer os.Chdir(originalDir)
 
-	// Initialize result map
-	result := make(map[string][]CommitInfo)
+	// Cancel in-flight git commands on Ctrl-C so the original branch can be
+	// restored before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	runCtx = ctx
+	go func() {
+		<-ctx.Done()
+		// Restore the default handling so a second Ctrl-C exits right away
+		// if restoring the branch hangs
+		stop()
+	}()
+
+	bareRepo, err = isBareRepository()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: '%s' is not a git repository: %v\n", directory, err)
+		os.Exit(exitCodeForError(err))
+	}
+	if bareRepo && inclWorktree {
+		fmt.Fprintf(os.Stderr, "Error: --include-worktree needs a working tree, '%s' is a bare repository\n", directory)
+		os.Exit(ExitUsage)
+	}
+	if branchesOnly {
+		// Nothing is checked out, so the state of the working tree doesn't
+		// matter
+		exists, err := checkBranchesExist(selectedEnvs, quickMode)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitCodeForError(err))
+		}
+		output, err := json.MarshalIndent(envMap[bool](exists), "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to marshal JSON: %v\n", err)
+			os.Exit(ExitOutputFailure)
+		}
+		fmt.Println(string(output))
+		return
+	}
+
+	if err := checkRepositoryState(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: repository '%s' can't be checked: %v\n", directory, err)
+		os.Exit(exitCodeForError(err))
+	}
+
+	originalRef, err := getCurrentRef()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error determining the checked out branch: %v\n", err)
+		os.Exit(exitCodeForError(err))
+	}
+
+	if watchInt > 0 {
+		watch(selectedEnvs, redactRe)
+		restoreOriginalRef(originalRef)
+		return
+	}
+
+	result, meta, failure := collectResults(selectedEnvs)
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "Interrupted, restoring branch '%s'\n", originalRef)
+		restoreOriginalRef(originalRef)
+		os.Exit(ExitInterrupted)
+	}
+	restoreOriginalRef(originalRef)
 
-	// Map of all possible branches
-	allBranches := map[string]string{
-		"main":                      "int",
-		"release/hcp/public/stg":    "stg",
-		"release/hcp/public/prod":   "prod",
+	finishRun(result, meta, redactRe, selectedEnvs, failure)
+}
+
+// watch re-processes the branches every watchInt and re-renders the result
+// until interrupted
+func watch(selectedEnvs []string, redactRe *regexp.Regexp) {
+	for {
+		// Failed branches are reported, the next cycle may succeed
+		result, meta, _ := collectResults(selectedEnvs)
+		if runCtx.Err() != nil {
+			// Interrupted mid-cycle, the result is likely incomplete
+			return
+		}
+
+		if stdoutFormat() == "table" {
+			// Clear the screen so the table redraws in place
+			fmt.Print("\033[H\033[2J")
+		}
+		if err := printResult(result, meta, redactRe); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		} else if failIfMissing {
+			// Keep watching, the branch may show up in a later cycle
+			if err := checkMissingEnvironments(result, selectedEnvs); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+		}
+		if stateFile != "" {
+			if err := updateState(result); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+		}
+
+		// Wait a full interval after each cycle rather than running on a
+		// fixed schedule, so slow cycles never overlap on the working tree
+		select {
+		case <-runCtx.Done():
+			return
+		case <-time.After(watchInt):
+		}
+	}
+}
+
+func restoreOriginalRef(ref string) {
+	if bareRepo || noCheckout {
+		return // Nothing was checked out
 	}
+	if err := restoreRef(ref); err != nil {
+		fmt.Fprintf(os.Stderr, "Error restoring branch '%s': %v\n", ref, err)
+	}
+}
+
+// collectResults processes the branches of the selected environments and
+// returns their commits keyed by environment name. failure is the error of the
+// first branch that couldn't be processed, nil if all were.
+func collectResults(selectedEnvs []string) (result map[string][]CommitInfo, meta resultMeta, failure error) {
+	// Initialize result map
+	result = make(map[string][]CommitInfo)
+	meta = resultMeta{Environments: make(map[string]*envMeta)}
 
 	// Filter branches based on selected environments
 	selectedEnvsMap := make(map[string]bool)
@@ -123,154 +868,849 @@ func runCommand(cmd *cobra.Command, args []string) {
 		selectedEnvsMap[env] = true
 	}
 
-	for branch, envName := range allBranches {
+	// Group the branches by remote, keeping the environment order
+	var remotes []string
+	selectedBranches := make(map[string][]string)
+	for _, eb := range allBranches {
+		if selectedEnvsMap[eb.Env] {
+			remote := remoteForEnv(eb.Env)
+			if _, ok := selectedBranches[remote]; !ok {
+				remotes = append(remotes, remote)
+			}
+			selectedBranches[remote] = append(selectedBranches[remote], eb.Branch)
+		}
+	}
+
+	recordGitCommands(recordCmds)
+	defer recordGitCommands(false)
+
+	// failBranch reports a branch that couldn't be processed. Errors without
+	// a category are git failures, e.g. a missing remote branch.
+	failBranch := func(envName, branch string, err error) {
+		fmt.Fprintf(os.Stderr, "Error processing branch '%s': %v\n", branch, err)
+		if !errors.Is(err, ErrGitOperation) && !errors.Is(err, ErrFileNotFound) && !errors.Is(err, ErrRevisionNotFound) {
+			err = markError(ErrGitOperation, err)
+		}
+		if failure == nil {
+			failure = err
+		}
+		meta.Warnings = append(meta.Warnings, Warning{
+			Category: WarningBranchFailed,
+			Env:      envName,
+			Message:  fmt.Sprintf("failed to process branch '%s': %v", branch, err),
+		})
+	}
+
+	// Processing the branches may check out others, so the working tree is
+	// read first
+	var worktree *worktreeFile
+	if inclWorktree {
+		var err error
+		worktree, err = readWorktreeFile(revFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			meta.Warnings = append(meta.Warnings, Warning{Category: WarningWorktree, Message: err.Error()})
+		}
+	}
+
+	fetchErrs := make(map[string]error)
+	fetchCommands := make(map[string][][]string)
+	for _, remote := range remotes {
+		if !quickMode && ghClient == nil {
+			// Fetch once up front to ensure we have latest remote refs
+			fetchErrs[remote] = fetchBranches(remote, selectedBranches[remote])
+		}
+		// Every environment of the remote depends on the shared fetch
+		fetchCommands[remote] = takeRecordedGitCommands()
+	}
+
+	for _, eb := range allBranches {
+		branch, envName := eb.Branch, eb.Env
 		if !selectedEnvsMap[envName] {
 			continue // Skip this environment if not selected
 		}
 
-		commits, err := processBranch(branch, quickMode, days)
+		if runCtx.Err() != nil {
+			break // Interrupted
+		}
+
+		remote := remoteForEnv(envName)
+		if fetchErr := fetchErrs[remote]; fetchErr != nil {
+			failBranch(envName, branch, fetchErr)
+			continue
+		}
+
+		// Drop the commands of a previous environment that failed
+		takeRecordedGitCommands()
+
+		envInfo := &envMeta{Ref: envRefs[envName]}
+		if isBranchPattern(branch) && envInfo.Ref == "" {
+			resolved, err := resolveBranchPattern(branch, remote)
+			if err != nil {
+				failBranch(envName, branch, err)
+				continue
+			}
+			envInfo.BranchPattern = branch
+			branch = resolved
+		}
+		envInfo.Branch = branch
+
+		source := revisionSource{
+			FilePath: revFile,
+			VarName:  varNameForEnv(envName),
+		}
+		if bareRepo {
+			// Without a working tree paths can't be relative to the current
+			// directory, so make them relative to the repository root
+			source.FilePath = path.Clean(revFile)
+		}
+
+		history := historyOptions{
+			DaysBack:      daysForEnv(envName),
+			ExcludeMerges: noMerges,
+			Follow:        follow,
+			ShowWorkers:   showWork,
+			TagPattern:    tagHistory,
+			Signatures:    verifySig,
+			Now:           currentTime(),
+			AsOf:          !fixedNow.IsZero(),
+		}
+		var branchRes *branchResult
+		var err error
+		if ghClient != nil {
+			branchRes, err = processGitHubBranch(ghClient, branch, noTip, source, history)
+		} else {
+			var prefix string
+			if noCheckout {
+				prefix = refPrefix
+			}
+			branchRes, err = processBranch(branch, branchOptions{
+				Remote:        remote,
+				Quick:         quickMode,
+				Bare:          bareRepo,
+				CommitsBehind: behind,
+				NoTip:         noTip,
+				AllowLocal:    allowLocal,
+				KeepWorktree:  inclWorktree,
+				RefPrefix:     prefix,
+				Ref:           envInfo.Ref,
+				// Outside of quick mode the branch was just reset to the
+				// remote, and with --ref-prefix or --ref the local branch
+				// isn't read
+				CheckStale:  quickMode && !noCheckout && envInfo.Ref == "",
+				CheckPushed: quickMode && !bareRepo && !noCheckout && envInfo.Ref == "",
+			}, source, history)
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error processing branch '%s': %v\n", branch, err)
+			failBranch(envName, branch, err)
 			continue
 		}
 
+		if worktree != nil && worktree.Branch == branch && envInfo.Ref == "" && len(branchRes.Commits) > 0 {
+			entry, err := worktree.uncommittedEntry(source.VarName, branchRes.Commits[0].RepoRevision)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				meta.Warnings = append(meta.Warnings, Warning{Category: WarningWorktree, Env: envName, Message: err.Error()})
+			} else if entry != nil {
+				// The working tree is newer than any commit
+				branchRes.Commits = append([]CommitInfo{*entry}, branchRes.Commits...)
+			}
+		}
+
 		// Convert all commit dates to UTC and add to result
-		var commitInfos []CommitInfo
-		for _, commit := range commits {
+		commitInfos := []CommitInfo{}
+		for _, commit := range branchRes.Commits {
 			utcDate, err := convertToUTC(commit.CommitDate)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Error converting date to UTC for branch '%s', commit '%s': %v\n", branch, commit.RepoRevision, err)
+				meta.Warnings = append(meta.Warnings, Warning{
+					Category: WarningDateParse,
+					Env:      envName,
+					Message:  fmt.Sprintf("failed to convert a commit date on branch '%s', the commit was left out: %v", branch, err),
+				})
 				continue
 			}
 
-			commitInfos = append(commitInfos, CommitInfo{
-				RepoRevision: commit.RepoRevision,
-				CommitDate:   utcDate,
-			})
+			commit.CommitDate = utcDate
+			if inclBranch {
+				commit.Branch = branch
+			}
+			commitInfos = append(commitInfos, commit)
 		}
 
 		result[envName] = commitInfos
+
+		if daysForEnv(envName) > 0 {
+			count := branchRes.WindowCommitCount
+			envInfo.CommitCount = &count
+		}
+		for _, skipped := range branchRes.SkippedCommits {
+			meta.Warnings = append(meta.Warnings, Warning{
+				Category: WarningSkippedCommit,
+				Env:      envName,
+				Commit:   skipped.CommitHash,
+				Message:  skipped.Reason,
+			})
+		}
+		if len(branchRes.SkippedCommits) > 0 {
+			if verbose {
+				fmt.Fprintf(os.Stderr, "Warning: skipped %d of %d history commits on branch '%s'\n", len(branchRes.SkippedCommits), branchRes.WindowCommitCount, branch)
+				for _, skipped := range branchRes.SkippedCommits {
+					fmt.Fprintf(os.Stderr, "  %s (%s): %s\n", skipped.CommitHash, skipped.CommitDate, skipped.Reason)
+				}
+			}
+			if inclErrors {
+				for _, skipped := range branchRes.SkippedCommits {
+					if utcDate, err := convertToUTC(skipped.CommitDate); err == nil {
+						skipped.CommitDate = utcDate
+					}
+					envInfo.SkippedCommits = append(envInfo.SkippedCommits, skipped)
+				}
+			}
+		}
+		envInfo.StaleRelativeToRemote = branchRes.Stale
+		envInfo.Pushed = branchRes.Pushed
+		printWarnings(branchRes.Warnings)
+		for _, w := range branchRes.Warnings {
+			w.Env = envName
+			meta.Warnings = append(meta.Warnings, w)
+		}
+		if recordCmds {
+			envInfo.GitCommands = append(append([][]string{}, fetchCommands[remote]...), takeRecordedGitCommands()...)
+		}
+		meta.Environments[envName] = envInfo
+	}
+
+	if deployedURLTmpl != "" && runCtx.Err() == nil {
+		checkDeployedRevisions(result, meta)
+	}
+	if targetRepo != "" && runCtx.Err() == nil {
+		warnings := compareTargetRevisions(result, meta)
+		printWarnings(warnings)
+		meta.Warnings = append(meta.Warnings, warnings...)
+	}
+
+	return result, meta, failure
+}
+
+// isStaleRelativeToRemote reports whether the local branch points to a
+// different commit than its remote-tracking branch. ok is false if either
+// ref can't be resolved, e.g. because the remote was never fetched.
+func isStaleRelativeToRemote(branch, remote string) (stale bool, ok bool) {
+	localOutput, err := runGit("rev-parse", "--verify", "--quiet", "refs/heads/"+branch)
+	if err != nil {
+		return false, false
 	}
 
-	// Output JSON
-	jsonData, err := json.MarshalIndent(result, "", "  ")
+	remoteOutput, err := runGit("rev-parse", "--verify", "--quiet", "refs/remotes/"+remote+"/"+branch)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error marshalling JSON: %v\n", err)
-		os.Exit(1)
+		return false, false
 	}
 
-	fmt.Println(string(jsonData))
+	return strings.TrimSpace(string(localOutput)) != strings.TrimSpace(string(remoteOutput)), true
 }
 
-func processBranch(branch string, quick bool, daysBack int) ([]CommitInfo, error) {
-	if !quick {
-		// First fetch to ensure we have latest remote refs
-		fetchCmd := exec.Command("git", "fetch", "origin")
-		if err := fetchCmd.Run(); err != nil {
-			return nil, fmt.Errorf("failed to fetch from origin: %v", err)
+// isTipPushed reports whether the last commit on ref that changed filePath is
+// reachable from <remote>/<branch>. ok is false if that can't be determined,
+// e.g. because there is no remote-tracking branch.
+func isTipPushed(branch, remote, ref, filePath string) (pushed bool, ok bool) {
+	if _, isTag := tagName(branch); isTag {
+		return false, false
+	}
+	tipCommitHash, err := getLastCommitHashForFile(ref, filePath)
+	if err != nil || tipCommitHash == "" {
+		return false, false
+	}
+	remoteRef := "refs/remotes/" + remote + "/" + branch
+	if _, err := runGit("rev-parse", "--verify", "--quiet", remoteRef); err != nil {
+		return false, false
+	}
+
+	_, err = runGit("merge-base", "--is-ancestor", tipCommitHash, remoteRef)
+	var gitErr *GitCommandError
+	switch {
+	case err == nil:
+		return true, true
+	case errors.As(err, &gitErr) && gitErr.ExitCode == 1:
+		// Exit code 1 means not an ancestor, anything else is a failure
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// fetchBranches fetches only the given branches from remote, which is much
+// cheaper than a full fetch on repositories with many branches. It falls back
+// to a full fetch if the targeted one fails or if any of the branches is a
+// pattern, so that newly created matching branches are seen.
+//
+// Bare clones have no fetch refspec configured, so a plain fetch would only
+// update FETCH_HEAD. There the branches are fetched into their
+// remote-tracking refs explicitly.
+func fetchBranches(remote string, branches []string) error {
+	targeted := true
+	hasTags := false
+	var refspecs []string
+	for _, branch := range branches {
+		if tag, ok := tagName(branch); ok {
+			// Tags may be moved on the remote, so force the update
+			refspecs = append(refspecs, "+refs/tags/"+tag+":refs/tags/"+tag)
+			hasTags = true
+			continue
 		}
+		if isBranchPattern(branch) {
+			targeted = false
+		}
+		if bareRepo {
+			refspecs = append(refspecs, "+refs/heads/"+branch+":refs/remotes/"+remote+"/"+branch)
+			continue
+		}
+		refspecs = append(refspecs, branch)
+	}
 
+	if targeted {
+		if _, err := runGit(append([]string{"fetch", remote}, refspecs...)...); err == nil {
+			return nil
+		}
+	}
+
+	fetchArgs := []string{"fetch", remote}
+	if bareRepo {
+		fetchArgs = append(fetchArgs, "+refs/heads/*:refs/remotes/"+remote+"/*")
+	}
+	if hasTags {
+		// A full fetch only follows tags pointing into fetched history
+		fetchArgs = append(fetchArgs, "--tags", "--force")
+	}
+	if _, err := runGit(fetchArgs...); err != nil {
+		return fmt.Errorf("failed to fetch from %s: %w", remote, err)
+	}
+	return nil
+}
+
+// branchOptions controls how processBranch reads a branch
+type branchOptions struct {
+	// Remote is the remote the branch is reset to and compared with
+	Remote string
+	// Quick uses the local branch as-is instead of resetting it to the remote
+	Quick bool
+	// Bare reads everything through git objects since there is no working
+	// tree to check the branch out into
+	Bare bool
+	// CommitsBehind sets CommitsBehindHead on the tip entry
+	CommitsBehind bool
+	// NoTip leaves out the tip entry and only reports the history
+	NoTip bool
+	// CheckStale sets Stale in the result
+	CheckStale bool
+	// CheckPushed sets Pushed in the result
+	CheckPushed bool
+	// AllowLocal reads the local branch without resetting it when the remote
+	// has no such branch
+	AllowLocal bool
+	// KeepWorktree reads the local branch through git objects in quick mode
+	// instead of checking it out, so uncommitted edits stay in place and
+	// don't end up in the tip entry
+	KeepWorktree bool
+	// RefPrefix reads the branch from refs/remotes/<RefPrefix><branch>
+	// through git objects instead of checking it out, if set
+	RefPrefix string
+	// Ref reads this commit-ish through git objects instead of the branch,
+	// if set
+	Ref string
+}
+
+// branchResult is what processBranch found on a branch
+type branchResult struct {
+	// Commits holds the tip commit followed by the history
+	Commits []CommitInfo
+	// WindowCommitCount is the number of commits that changed the revision
+	// file within the history window, including the tip if it falls inside
+	WindowCommitCount int
+	// SkippedCommits are the history commits whose revision couldn't be read
+	SkippedCommits []SkippedCommit
+	// Stale tells whether the local branch differs from <remote>/<branch>
+	// and Pushed whether the tip commit is on it, nil if it wasn't checked
+	// or couldn't be determined
+	Stale  *bool
+	Pushed *bool
+	// Warnings are the problems the branch was processed despite of. The
+	// commits that were skipped are in SkippedCommits instead.
+	Warnings []Warning
+}
+
+// processBranch expects the remote refs to be fetched already unless
+// opts.Quick is set
+func processBranch(branch string, opts branchOptions, source revisionSource, history historyOptions) (*branchResult, error) {
+	var warnings []Warning
+
+	// readRef is the revision the file and its history are read from
+	readRef := "HEAD"
+	// readFromObjects reads the tip revision from readRef instead of the
+	// working tree
+	readFromObjects := opts.Bare
+
+	if opts.Ref != "" {
+		// Pinned with --ref, so neither the branch nor the remote matter
+		output, err := runGit("rev-parse", "--verify", "--quiet", opts.Ref+"^{commit}")
+		if err != nil {
+			return nil, fmt.Errorf("commit-ish '%s' of --ref doesn't exist: %w", opts.Ref, err)
+		}
+		readRef = strings.TrimSpace(string(output))
+		readFromObjects = true
+	} else if tag, ok := tagName(branch); ok {
+		// There is no remote branch to reset to, and checking out the tag
+		// would detach HEAD, so read the tag directly
+		readRef = "refs/tags/" + tag
+		readFromObjects = true
+	} else if opts.RefPrefix != "" {
+		readRef = "refs/remotes/" + opts.RefPrefix + branch
+		readFromObjects = true
+		if _, err := runGit("rev-parse", "--verify", "--quiet", readRef); err != nil {
+			if !opts.AllowLocal {
+				return nil, markError(ErrGitOperation, fmt.Errorf("remote-tracking ref %s doesn't exist, use --allow-local to read the local branch '%s'", readRef, branch))
+			}
+			warnings = append(warnings, Warning{
+				Category: WarningLocalBranch,
+				Message:  fmt.Sprintf("remote-tracking ref %s doesn't exist, reading the local branch '%s' instead", readRef, branch),
+			})
+			readRef = "refs/heads/" + branch
+		}
+	} else if opts.Bare && opts.Quick {
+		readRef = resolveBareRef(branch, opts.Remote)
+	} else if opts.Bare {
+		// The fetch updated the remote-tracking ref, the local branch of a
+		// bare clone is never moved and would be stale
+		readRef = "refs/remotes/" + opts.Remote + "/" + branch
+		if _, err := runGit("rev-parse", "--verify", "--quiet", readRef); err != nil {
+			if !opts.AllowLocal {
+				return nil, markError(ErrGitOperation, fmt.Errorf("remote branch %s/%s doesn't exist, use --allow-local to read the local branch '%s'", opts.Remote, branch, branch))
+			}
+			warnings = append(warnings, Warning{
+				Category: WarningLocalBranch,
+				Message:  fmt.Sprintf("remote branch %s/%s doesn't exist, reading the local branch '%s' as-is", opts.Remote, branch, branch),
+			})
+			readRef = "refs/heads/" + branch
+		}
+	} else if opts.Quick && opts.KeepWorktree {
+		readRef = "refs/heads/" + branch
+		readFromObjects = true
+		if _, err := runGit("rev-parse", "--verify", "--quiet", readRef); err != nil {
+			// git checkout would create the branch from the remote one
+			readRef = "refs/remotes/" + opts.Remote + "/" + branch
+		}
+	} else if !opts.Quick {
 		// Checkout the branch
-		checkoutCmd := exec.Command("git", "checkout", branch)
-		if err := checkoutCmd.Run(); err != nil {
-			return nil, fmt.Errorf("failed to checkout branch '%s': %v", branch, err)
+		if _, err := runGit("checkout", branch); err != nil {
+			return nil, fmt.Errorf("failed to checkout branch '%s': %w", branch, err)
 		}
 
 		// Reset to match the remote branch exactly
-		resetCmd := exec.Command("git", "reset", "--hard", fmt.Sprintf("origin/%s", branch))
-		if err := resetCmd.Run(); err != nil {
-			return nil, fmt.Errorf("failed to reset to origin/%s: %v", branch, err)
+		remoteRef := opts.Remote + "/" + branch
+		if _, err := runGit("rev-parse", "--verify", "--quiet", "refs/remotes/"+remoteRef); err != nil {
+			if !opts.AllowLocal {
+				return nil, markError(ErrGitOperation, fmt.Errorf("remote branch %s doesn't exist, use --allow-local to read the local branch '%s'", remoteRef, branch))
+			}
+			warnings = append(warnings, Warning{
+				Category: WarningLocalBranch,
+				Message:  fmt.Sprintf("remote branch %s doesn't exist, reading the local branch '%s' as-is", remoteRef, branch),
+			})
+		} else if _, err := runGit("reset", "--hard", remoteRef); err != nil {
+			return nil, fmt.Errorf("failed to reset to %s: %w", remoteRef, err)
 		}
 	} else {
 		// In quick mode, just checkout the branch without fetching/resetting
-		checkoutCmd := exec.Command("git", "checkout", branch)
-		if err := checkoutCmd.Run(); err != nil {
-			return nil, fmt.Errorf("failed to checkout branch '%s': %v", branch, err)
+		if _, err := runGit("checkout", branch); err != nil {
+			return nil, fmt.Errorf("failed to checkout branch '%s': %w", branch, err)
+		}
+	}
+
+	if history.AsOf {
+		// The tip is the state of the branch at --now, not its current head,
+		// so everything below is read from the last commit before it
+		output, err := runGit("rev-list", "-1", "--before="+history.Now.Format(time.RFC3339), readRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the commit of branch '%s' at %s: %w", branch, history.Now.Format(time.RFC3339), err)
+		}
+		asOfCommit := strings.TrimSpace(string(output))
+		if asOfCommit == "" {
+			return nil, markError(ErrFileNotFound, fmt.Errorf("branch '%s' has no commit before %s", branch, history.Now.Format(time.RFC3339)))
+		}
+		readRef = asOfCommit
+		readFromObjects = true
+	}
+
+	if !noFollowLinks {
+		// git log/show would otherwise track the symlink itself rather than
+		// the file holding the revision
+		var resolved string
+		var err error
+		if readFromObjects {
+			resolved, err = resolveSymlinkAtRef(readRef, source.FilePath)
+		} else {
+			resolved, err = resolveSymlinkedFile(source.FilePath)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve '%s' on branch '%s': %w", source.FilePath, branch, err)
+		}
+		source.FilePath = resolved
+	}
+
+	// The history of a file inside a submodule is only visible from within
+	// the submodule, starting at the commit the superproject points to
+	submodule, err := findSubmodule(readRef, source.FilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up submodules for '%s' on branch '%s': %w", source.FilePath, branch, err)
+	}
+	if submodule != nil {
+		if opts.Bare {
+			return nil, fmt.Errorf("'%s' is inside submodule '%s', which can't be read in a bare repository", source.FilePath, submodule.Path)
+		}
+		restoreDir, err := enterSubmodule(submodule.Path)
+		if err != nil {
+			return nil, err
 		}
+		defer restoreDir()
+
+		readRef = submodule.Commit
+		source.FilePath = submodule.FilePath
+		readFromObjects = true
 	}
 
 	var commits []CommitInfo
+	var windowCount int
+	var skippedCommits []SkippedCommit
 
 	// Always get the tip commit first
-	tipRevision, err := extractRevision("./hcp/Revision.mk")
+	var tipRevision string
+	if readFromObjects {
+		tipRevision, err = extractRevisionAtRef(readRef, source)
+	} else {
+		tipRevision, err = extractRevision(source.FilePath, source.VarName)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to extract revision from Revision.mk on branch '%s': %v", branch, err)
+		return nil, fmt.Errorf("failed to extract revision from '%s' on branch '%s': %w", source.FilePath, branch, err)
 	}
 
-	// Get the commit date of the last change to Revision.mk
-	commitDateCmd := exec.Command("git", "log", "-1", "--format=%ci", "--", "./hcp/Revision.mk")
-	commitDateOutput, err := commitDateCmd.Output()
+	// Get the commit date of the last change to the revision file
+	commitDateOutput, err := runGit("log", "-1", "--format=%cI", readRef, "--", source.FilePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get commit date for Revision.mk on branch '%s': %v", branch, err)
+		return nil, fmt.Errorf("failed to get commit date for '%s' on branch '%s': %w", source.FilePath, branch, err)
 	}
 	tipCommitDate := strings.TrimSpace(string(commitDateOutput))
 
 	// Add tip commit as first entry
-	commits = append(commits, CommitInfo{
+	tip := CommitInfo{
 		RepoRevision: tipRevision,
 		CommitDate:   tipCommitDate,
-	})
+	}
+	if history.Signatures {
+		sig, err := lastCommitSignature(readRef, source.FilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify the signature on branch '%s': %w", branch, err)
+		}
+		tip.setSignature(sig)
+	}
+	if opts.CommitsBehind && !opts.NoTip {
+		count, err := countCommitsBehindHead(readRef, source.FilePath)
+		if err != nil {
+			warnings = append(warnings, Warning{
+				Category: WarningCommitsBehind,
+				Message:  fmt.Sprintf("failed to count commits behind HEAD for branch '%s': %v", branch, err),
+			})
+		} else {
+			tip.CommitsBehindHead = &count
+		}
+	}
+	if !opts.NoTip {
+		commits = append(commits, tip)
+	}
+
+	var stale *bool
+	if opts.CheckStale {
+		if isStale, ok := isStaleRelativeToRemote(branch, opts.Remote); ok {
+			stale = &isStale
+			if isStale {
+				warnings = append(warnings, Warning{
+					Category: WarningStale,
+					Message:  fmt.Sprintf("local branch '%s' differs from '%s/%s', quick mode results may be out of date", branch, opts.Remote, branch),
+				})
+			}
+		}
+	}
+
+	var pushed *bool
+	if opts.CheckPushed && submodule == nil {
+		if isPushed, ok := isTipPushed(branch, opts.Remote, readRef, source.FilePath); ok {
+			pushed = &isPushed
+			if !isPushed {
+				warnings = append(warnings, Warning{
+					Category: WarningUnpushed,
+					Message:  fmt.Sprintf("the last change to '%s' on branch '%s' isn't on '%s/%s', the result reflects unpushed commits", source.FilePath, branch, opts.Remote, branch),
+				})
+			}
+		}
+	}
+
+	if tag, ok := tagName(branch); ok && history.TagPattern != "" && submodule == nil && opts.Ref == "" {
+		if !opts.NoTip {
+			commits[0].Tag = tag
+		}
+		tagCommits, skipped, err := getTagHistory(history.TagPattern, tag, source, opts.NoTip)
+		if err != nil {
+			return nil, err
+		}
+		return &branchResult{
+			Commits:           append(commits, tagCommits...),
+			WindowCommitCount: len(tagCommits) + len(skipped),
+			SkippedCommits:    skipped,
+			Stale:             stale,
+			Pushed:            pushed,
+			Warnings:          warnings,
+		}, nil
+	}
 
 	// If days is specified, get historical commits
-	if daysBack > 0 {
-		historicalCommits, err := getHistoricalCommits("./hcp/Revision.mk", daysBack)
+	if history.DaysBack > 0 {
+		history.Ref = readRef
+		if !opts.Quick {
+			history.Refetch = opts.Remote
+		}
+		historicalCommits, skipped, err := getHistoricalCommits(source, history)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get historical commits for Revision.mk on branch '%s': %v", branch, err)
+			return nil, fmt.Errorf("failed to get historical commits for '%s' on branch '%s': %w", source.FilePath, branch, err)
 		}
+		windowCount = len(historicalCommits) + len(skipped)
+		skippedCommits = skipped
 
 		// Add historical commits (excluding tip if it's already included)
-		tipCommitHash, err := getLastCommitHashForFile("./hcp/Revision.mk")
-		if err == nil {
+		tipCommitHash, err := getLastCommitHashForFile(readRef, source.FilePath)
+		if err == nil && !opts.NoTip {
 			for _, commit := range historicalCommits {
 				if commit.CommitHash != tipCommitHash {
-					commits = append(commits, CommitInfo{
-						RepoRevision: commit.RepoRevision,
-						CommitDate:   commit.CommitDate,
-					})
+					commits = append(commits, commit.commitInfo())
 				}
 			}
 		} else {
-			// If we can't get tip hash, just add all historical commits
+			// Without a tip entry, or if we can't get the tip hash, just add
+			// all historical commits
 			for _, commit := range historicalCommits {
-				commits = append(commits, CommitInfo{
-					RepoRevision: commit.RepoRevision,
-					CommitDate:   commit.CommitDate,
-				})
+				commits = append(commits, commit.commitInfo())
 			}
 		}
 	}
 
-	return commits, nil
+	return &branchResult{
+		Commits:           commits,
+		WindowCommitCount: windowCount,
+		SkippedCommits:    skippedCommits,
+		Stale:             stale,
+		Pushed:            pushed,
+		Warnings:          warnings,
+	}, nil
 }
 
-func extractRevision(filePath string) (string, error) {
-	content, err := os.ReadFile(filePath)
+// revisionSource describes where the revision value is read from
+type revisionSource struct {
+	FilePath string
+	VarName  string
+}
+
+// resolveSymlinkedFile returns filePath unchanged unless it is a symlink, in
+// which case it returns the path of the link target relative to the current
+// directory. Targets outside the repository are rejected since git can't
+// track their history.
+func resolveSymlinkedFile(filePath string) (string, error) {
+	info, err := os.Lstat(filePath)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		// Missing files are reported by the extraction
+		return filePath, nil
+	}
+
+	target, err := filepath.EvalSymlinks(filePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to read file '%s': %v", filePath, err)
+		return "", err
+	}
+	target, err = filepath.Abs(target)
+	if err != nil {
+		return "", err
 	}
 
-	// Look for ARO_HCP_REPO_REVISION= pattern
-	re := regexp.MustCompile(`ARO_HCP_REPO_REVISION\s*=\s*(.+)`)
-	matches := re.FindStringSubmatch(string(content))
+	output, err := runGit("rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", fmt.Errorf("failed to determine the repository root: %w", err)
+	}
+	root, err := filepath.EvalSymlinks(strings.TrimSpace(string(output)))
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(root, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("symlink points to '%s' outside of the repository", target)
+	}
 
-	if len(matches) < 2 {
-		return "", fmt.Errorf("ARO_HCP_REPO_REVISION not found in '%s'", filePath)
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	cwd, err = filepath.EvalSymlinks(cwd)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(cwd, target)
+	if err != nil {
+		return "", err
 	}
+	return "./" + filepath.ToSlash(rel), nil
+}
 
-	// Clean up the value (remove quotes if present and trim whitespace)
-	revision := strings.TrimSpace(matches[1])
-	revision = strings.Trim(revision, "\"'")
+// submoduleLocation is a revision file found inside a submodule
+type submoduleLocation struct {
+	// Path is the submodule directory relative to the repository root
+	Path string
+	// Commit is the submodule commit recorded in the superproject
+	Commit string
+	// FilePath is the revision file relative to the submodule
+	FilePath string
+}
+
+// findSubmodule returns the submodule at ref that contains filePath, or nil if
+// the file isn't inside a submodule
+func findSubmodule(ref, filePath string) (*submoduleLocation, error) {
+	cleanPath := path.Clean(filePath)
+	parts := strings.Split(cleanPath, "/")
+	if len(parts) < 2 {
+		return nil, nil
+	}
+
+	// ls-tree reports every parent directory given, a submodule shows up as
+	// a commit entry
+	args := []string{"ls-tree", ref, "--"}
+	for i := 1; i < len(parts); i++ {
+		args = append(args, strings.Join(parts[:i], "/"))
+	}
+	output, err := runGit(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		// <mode> SP <type> SP <object> TAB <path>
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		entry := strings.Fields(fields[0])
+		if len(entry) != 3 || entry[1] != "commit" {
+			continue
+		}
+		return &submoduleLocation{
+			Path:     fields[1],
+			Commit:   entry[2],
+			FilePath: strings.TrimPrefix(cleanPath, fields[1]+"/"),
+		}, nil
+	}
+	return nil, nil
+}
+
+// enterSubmodule changes into the submodule directory and returns a function
+// that changes back. It fails if the submodule isn't initialized, since its
+// history isn't available then.
+func enterSubmodule(submodulePath string) (func(), error) {
+	originalDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chdir(submodulePath); err != nil {
+		return nil, fmt.Errorf("submodule '%s' is not initialized: %w", submodulePath, err)
+	}
+
+	// An uninitialized submodule is a plain directory inside the superproject
+	output, err := runGit("rev-parse", "--show-prefix")
+	if err != nil || strings.TrimSpace(string(output)) != "" {
+		os.Chdir(originalDir)
+		return nil, fmt.Errorf("submodule '%s' is not initialized, run 'git submodule update --init'", submodulePath)
+	}
+
+	return func() { os.Chdir(originalDir) }, nil
+}
+
+// resolveBareRef returns the ref to read branch from in a bare repository in
+// quick mode, where nothing is fetched. Bare clones that were fetched before
+// have remote-tracking branches, mirrors only have the branch itself.
+func resolveBareRef(branch, remote string) string {
+	remoteRef := remote + "/" + branch
+	if _, err := runGit("rev-parse", "--verify", "--quiet", remoteRef); err == nil {
+		return remoteRef
+	}
+	return branch
+}
+
+// extractRevisionAtRef reads the revision file from ref without using the
+// working tree
+func extractRevisionAtRef(ref string, source revisionSource) (string, error) {
+	revision, err := extractRevisionAtCommit(ref, source)
+	if errors.Is(err, ErrGitOperation) {
+		return "", err
+	}
+	if err != nil {
+		return "", &ExtractionError{FilePath: source.FilePath, VarName: source.VarName, Ref: ref, Err: err}
+	}
+
+	return revision, nil
+}
+
+func extractRevision(filePath, varName string) (string, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = markError(ErrFileNotFound, err)
+		}
+		return "", fmt.Errorf("failed to read file '%s': %w", filePath, err)
+	}
+
+	revision, err := extractRevisionFromContent(string(content), filePath, varName)
+	if err != nil {
+		return "", &ExtractionError{FilePath: filePath, VarName: varName, Err: err}
+	}
 
 	return revision, nil
 }
 
-func extractRevisionFromContent(content string) (string, error) {
-	// Look for ARO_HCP_REPO_REVISION= pattern
-	re := regexp.MustCompile(`ARO_HCP_REPO_REVISION\s*=\s*(.+)`)
+// extractRevisionFromContent extracts varName from content, parsing it
+// according to the extension of filePath
+func extractRevisionFromContent(content, filePath, varName string) (string, error) {
+	if isLFSPointer(content) {
+		return "", markError(ErrRevisionNotFound, errLFSPointer)
+	}
+
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yaml", ".yml":
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal([]byte(content), &doc); err != nil {
+			return "", fmt.Errorf("failed to parse YAML: %v", err)
+		}
+		return lookupRevisionKey(doc, varName)
+	case ".json":
+		var doc map[string]interface{}
+		if err := json.Unmarshal([]byte(content), &doc); err != nil {
+			return "", fmt.Errorf("failed to parse JSON: %v", err)
+		}
+		return lookupRevisionKey(doc, varName)
+	}
+
+	// Anything else is treated as a Makefile; look for a VAR = value line,
+	// optionally exported. Anchoring at the line start keeps e.g.
+	// MY_ARO_HCP_REPO_REVISION from matching.
+	flags := "(?m)"
+	if caseInsensitive {
+		// Only the name is matched, the value is used as written
+		flags = "(?mi)"
+	}
+	re := regexp.MustCompile(flags + `^[ \t]*(?:export[ \t]+)?` + regexp.QuoteMeta(varName) + `[ \t]*=[ \t]*(.+)`)
 	matches := re.FindStringSubmatch(content)
 
 	if len(matches) < 2 {
-		return "", fmt.Errorf("ARO_HCP_REPO_REVISION not found in content")
+		return "", markError(ErrRevisionNotFound, fmt.Errorf("%s not found", varName))
 	}
 
 	// Clean up the value (remove quotes if present and trim whitespace)
@@ -280,87 +1720,320 @@ func extractRevisionFromContent(content string) (string, error) {
 	return revision, nil
 }
 
-func convertToUTC(dateStr string) (string, error) {
-	// Parse the git commit date (format: "2006-01-02 15:04:05 -0700")
-	parsedTime, err := time.Parse("2006-01-02 15:04:05 -0700", dateStr)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse date '%s': %v", dateStr, err)
+// lookupRevisionKey resolves a dot-separated key in a decoded YAML/JSON
+// document and returns its scalar value as a string
+func lookupRevisionKey(doc map[string]interface{}, key string) (string, error) {
+	var value interface{} = doc
+	for _, part := range strings.Split(key, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return "", markError(ErrRevisionNotFound, fmt.Errorf("%s not found", key))
+		}
+		value, ok = m[part]
+		if !ok {
+			return "", markError(ErrRevisionNotFound, fmt.Errorf("%s not found", key))
+		}
 	}
 
-	// Convert to UTC and format
-	utcTime := parsedTime.UTC()
-	return utcTime.Format("2006-01-02 15:04:05 +0000"), nil
+	switch v := value.(type) {
+	case map[string]interface{}, []interface{}, nil:
+		return "", fmt.Errorf("%s is not a scalar value", key)
+	case string:
+		return strings.TrimSpace(v), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// historyOptions controls which commits getHistoricalCommits reports
+type historyOptions struct {
+	DaysBack      int
+	ExcludeMerges bool
+	// Follow traverses renames of the file. git only supports this for a
+	// single pathspec.
+	Follow bool
+	// ShowWorkers bounds the number of concurrent batches reading the file
+	ShowWorkers int
+	// Ref is the revision whose history is read, HEAD if empty
+	Ref string
+	// Signatures sets the signature fields of every commit
+	Signatures bool
+	// TagPattern replaces the commit history of environments pinned to a tag
+	// with the tags matching it
+	TagPattern string
+	// Now is the end of the window. With AsOf, later commits are left out
+	// too, otherwise the window is open ended.
+	Now  time.Time
+	AsOf bool
+	// Refetch is the remote objects missing locally are fetched from before
+	// the commits needing them are read once more, empty to skip them
+	// right away
+	Refetch string
 }
 
 type HistoricalCommit struct {
-	CommitHash   string
-	CommitDate   string
+	CommitHash string
+	CommitDate string
+	// FilePath is the path of the revision file at this commit relative to
+	// the repository root, only set with Follow since it may differ from the
+	// current path before a rename
+	FilePath     string
 	RepoRevision string
+	// Signature is only set with historyOptions.Signatures
+	Signature *commitSignature
+}
+
+func (c HistoricalCommit) commitInfo() CommitInfo {
+	info := CommitInfo{
+		RepoRevision: c.RepoRevision,
+		CommitDate:   c.CommitDate,
+	}
+	if c.Signature != nil {
+		info.setSignature(*c.Signature)
+	}
+	return info
+}
+
+// minHistoryBatch is the smallest number of history commits read in one
+// batch. Splitting smaller windows across --show-workers would start more git
+// processes than it saves time.
+const minHistoryBatch = 20
+
+// logCommitLine matches the %H|%cI lines of getHistoricalCommits' git log
+var logCommitLine = regexp.MustCompile(`^[0-9a-f]{40,64}\|`)
+
+// SkippedCommit is a history commit that changed the revision file but whose
+// revision couldn't be read
+type SkippedCommit struct {
+	CommitHash string `json:"commit"`
+	CommitDate string `json:"commit_date"`
+	Reason     string `json:"reason"`
 }
 
 func getCurrentCommitHash() (string, error) {
-	cmd := exec.Command("git", "rev-parse", "HEAD")
-	output, err := cmd.Output()
+	output, err := runGit("rev-parse", "HEAD")
 	if err != nil {
 		return "", err
 	}
 	return strings.TrimSpace(string(output)), nil
 }
 
-func getLastCommitHashForFile(filePath string) (string, error) {
-	cmd := exec.Command("git", "log", "-1", "--format=%H", "--", filePath)
-	output, err := cmd.Output()
+func getLastCommitHashForFile(ref, filePath string) (string, error) {
+	output, err := runGit("log", "-1", "--format=%H", ref, "--", filePath)
 	if err != nil {
 		return "", err
 	}
 	return strings.TrimSpace(string(output)), nil
 }
 
-func getHistoricalCommits(filePath string, daysBack int) ([]HistoricalCommit, error) {
+// countCommitsBehindHead returns the number of commits on ref since the last
+// commit that changed filePath
+func countCommitsBehindHead(ref, filePath string) (int, error) {
+	commitHash, err := getLastCommitHashForFile(ref, filePath)
+	if err != nil {
+		return 0, err
+	}
+
+	output, err := runGit("rev-list", "--count", commitHash+".."+ref)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count commits since %s: %w", commitHash, err)
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected rev-list output '%s': %v", strings.TrimSpace(string(output)), err)
+	}
+	return count, nil
+}
+
+func getHistoricalCommits(source revisionSource, opts historyOptions) ([]HistoricalCommit, []SkippedCommit, error) {
 	// Get commits that modified the file in the last N days
-	sinceDate := time.Now().AddDate(0, 0, -daysBack).Format("2006-01-02")
+	sinceDate := opts.Now.AddDate(0, 0, -opts.DaysBack).Format("2006-01-02")
+
+	format := "%H|%cI"
+	if opts.Signatures {
+		format += "|" + signatureLogFormat
+	}
+	logArgs := []string{"log", "--since=" + sinceDate, "--format=" + format}
+	if opts.AsOf {
+		logArgs = append(logArgs, "--until="+opts.Now.Format(time.RFC3339))
+	}
+	if opts.Follow {
+		// --name-only lists the path the file had at each commit below the
+		// commit line
+		logArgs = append(logArgs, "--follow", "--name-only")
+	}
+	if opts.ExcludeMerges {
+		// Merge commits can touch the file through conflict resolution, which
+		// would double-report a revision that really came from another branch
+		logArgs = append(logArgs, "--no-merges")
+	}
+	if opts.Ref != "" {
+		logArgs = append(logArgs, opts.Ref)
+	}
+	logArgs = append(logArgs, "--", source.FilePath)
 
-	cmd := exec.Command("git", "log", "--since="+sinceDate, "--format=%H|%ci", "--", filePath)
-	output, err := cmd.Output()
+	output, err := runGit(logArgs...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get git log: %v", err)
+		return nil, nil, fmt.Errorf("failed to get git log: %w", err)
 	}
 
 	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	var commits []HistoricalCommit
+	var candidates []HistoricalCommit
 
 	for _, line := range lines {
 		if line == "" {
 			continue
 		}
 
-		parts := strings.Split(line, "|")
-		if len(parts) != 2 {
+		if !logCommitLine.MatchString(line) {
+			if opts.Follow && len(candidates) > 0 && candidates[len(candidates)-1].FilePath == "" {
+				candidates[len(candidates)-1].FilePath = line
+			}
 			continue
 		}
 
-		commitHash := parts[0]
-		commitDate := parts[1]
+		// The signer is last since the name may contain anything
+		fieldCount := 2
+		if opts.Signatures {
+			fieldCount = 4
+		}
+		parts := strings.SplitN(line, "|", fieldCount)
+		if len(parts) != fieldCount {
+			continue
+		}
 
-		// Get the file content at this specific commit
-		showCmd := exec.Command("git", "show", commitHash+":"+filePath)
-		fileContent, err := showCmd.Output()
-		if err != nil {
-			continue // Skip this commit if we can't get the file content
+		commit := HistoricalCommit{
+			CommitHash: parts[0],
+			CommitDate: parts[1],
 		}
+		if opts.Signatures {
+			sig := parseSignature(parts[2], parts[3])
+			commit.Signature = &sig
+		}
+		candidates = append(candidates, commit)
+	}
+
+	workers := opts.ShowWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	// The commits are read in batches of two git calls each, see
+	// extractRevisionsAtCommits. Reading objects is read-only, so the batches
+	// can run concurrently; each result lands at its log position to keep the
+	// original order.
+	batchSize := max((len(candidates)+workers-1)/workers, minHistoryBatch)
+	extracted := make([]bool, len(candidates))
+	skipReasons := make([]string, len(candidates))
+	missingErrs := make([]bool, len(candidates))
+	batchErrs := make([]error, len(candidates))
+	var wg sync.WaitGroup
+
+	for start := 0; start < len(candidates); start += batchSize {
+		end := min(start+batchSize, len(candidates))
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
 
-		// Extract revision from the file content at this commit
-		revision, err := extractRevisionFromContent(string(fileContent))
+			// Extract revision from the file content at each commit, at the
+			// path it had back then
+			refs := make([]string, 0, end-start)
+			sources := make([]revisionSource, 0, end-start)
+			for _, candidate := range candidates[start:end] {
+				commitSource := source
+				if candidate.FilePath != "" {
+					commitSource.FilePath = candidate.FilePath
+				}
+				refs = append(refs, candidate.CommitHash)
+				sources = append(sources, commitSource)
+			}
+
+			revisions, errs, err := extractRevisionsAtCommits(refs, sources)
+			if err != nil {
+				batchErrs[start] = err
+				return
+			}
+			for i := range revisions {
+				if errs[i] != nil {
+					skipReasons[start+i] = errs[i].Error()
+					missingErrs[start+i] = errors.Is(errs[i], errMissingObject)
+					continue
+				}
+				candidates[start+i].RepoRevision = revisions[i]
+				extracted[start+i] = true
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	for _, err := range batchErrs {
 		if err != nil {
-			continue // Skip this commit if we can't extract revision
+			return nil, nil, err
 		}
+	}
 
-		commits = append(commits, HistoricalCommit{
-			CommitHash:   commitHash,
-			CommitDate:   commitDate,
-			RepoRevision: revision,
-		})
+	// Shallow and partial clones may lack the objects of older commits
+	var missing []int
+	for i := range candidates {
+		if missingErrs[i] {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) > 0 && opts.Refetch == "" {
+		for _, i := range missing {
+			skipReasons[i] += " (not fetched in quick mode)"
+		}
+	} else if len(missing) > 0 {
+		var hashes []string
+		for _, i := range missing {
+			hashes = append(hashes, candidates[i].CommitHash)
+		}
+		if err := refetchMissingObjects(opts.Refetch, hashes); err != nil {
+			for _, i := range missing {
+				skipReasons[i] += fmt.Sprintf(" (fetching it from %s failed: %v)", opts.Refetch, err)
+			}
+		} else {
+			refs := make([]string, len(missing))
+			sources := make([]revisionSource, len(missing))
+			for j, i := range missing {
+				refs[j] = candidates[i].CommitHash
+				sources[j] = source
+				if candidates[i].FilePath != "" {
+					sources[j].FilePath = candidates[i].FilePath
+				}
+			}
+			revisions, errs, err := extractRevisionsAtCommits(refs, sources)
+			if err != nil {
+				return nil, nil, err
+			}
+			for j, i := range missing {
+				if errs[j] != nil {
+					skipReasons[i] = errs[j].Error()
+					if errors.Is(errs[j], errMissingObject) {
+						skipReasons[i] += fmt.Sprintf(" (still missing after fetching it from %s)", opts.Refetch)
+					}
+					continue
+				}
+				candidates[i].RepoRevision = revisions[j]
+				extracted[i] = true
+			}
+		}
 	}
 
-	return commits, nil
-}
\ No newline at end of file
+	var commits []HistoricalCommit
+	var skipped []SkippedCommit
+	for i, commit := range candidates {
+		if extracted[i] {
+			commits = append(commits, commit)
+		} else {
+			skipped = append(skipped, SkippedCommit{
+				CommitHash: commit.CommitHash,
+				CommitDate: commit.CommitDate,
+				Reason:     skipReasons[i],
+			})
+		}
+	}
+
+	return commits, skipped, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateNumericFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		days    int
+		envDays map[string]int
+		workers int
+		maxGit  int
+		timeout time.Duration
+		fetch   time.Duration
+		watch   time.Duration
+		wantErr string
+	}{
+		{name: "defaults", workers: 4},
+		{name: "all zero"},
+		{name: "positive values", days: 7, envDays: map[string]int{"prod": 90}, workers: 8, maxGit: 2, timeout: time.Minute, fetch: time.Minute, watch: time.Minute},
+		{name: "zero per-env days", envDays: map[string]int{"prod": 0}},
+		{name: "negative days", days: -1, wantErr: "--days must not be negative, got -1"},
+		{name: "negative per-env days", days: 7, envDays: map[string]int{"prod": -3}, wantErr: "--days must not be negative, got -3 for 'prod'"},
+		{name: "negative show workers", workers: -1, wantErr: "--show-workers must not be negative"},
+		{name: "negative max parallel git", maxGit: -2, wantErr: "--max-parallel-git must not be negative"},
+		{name: "negative timeout", timeout: -time.Second, wantErr: "--timeout must not be negative"},
+		{name: "negative fetch timeout", fetch: -time.Second, wantErr: "--fetch-timeout must not be negative"},
+		{name: "negative watch", watch: -time.Second, wantErr: "--watch must not be negative"},
+	}
+
+	defer func(d int, e map[string]int, w, m int, gt, ft, wi time.Duration) {
+		days, envDays, showWork, maxParallelGit, gitTimeout, fetchTimeout, watchInt = d, e, w, m, gt, ft, wi
+	}(days, envDays, showWork, maxParallelGit, gitTimeout, fetchTimeout, watchInt)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			days, envDays, showWork, maxParallelGit = tt.days, tt.envDays, tt.workers, tt.maxGit
+			gitTimeout, fetchTimeout, watchInt = tt.timeout, tt.fetch, tt.watch
+
+			err := validateNumericFlags()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected an error containing %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %q doesn't contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExtractRevisionFromContentMakefile(t *testing.T) {
+	const varName = "ARO_HCP_REPO_REVISION"
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "plain", content: "ARO_HCP_REPO_REVISION = abc123\n", want: "abc123"},
+		{name: "no spaces", content: "ARO_HCP_REPO_REVISION=abc123\n", want: "abc123"},
+		{name: "export", content: "export ARO_HCP_REPO_REVISION = abc123\n", want: "abc123"},
+		{name: "export with tabs", content: "export\tARO_HCP_REPO_REVISION\t=\tabc123\n", want: "abc123"},
+		{name: "indented export", content: "  export ARO_HCP_REPO_REVISION = abc123\n", want: "abc123"},
+		{name: "quoted", content: "ARO_HCP_REPO_REVISION = \"abc123\"\n", want: "abc123"},
+		{name: "prefixed name before", content: "MY_ARO_HCP_REPO_REVISION = other\nARO_HCP_REPO_REVISION = abc123\n", want: "abc123"},
+		{name: "prefixed name after", content: "ARO_HCP_REPO_REVISION = abc123\nMY_ARO_HCP_REPO_REVISION = other\n", want: "abc123"},
+		{name: "exported prefixed name", content: "export MY_ARO_HCP_REPO_REVISION = other\nexport ARO_HCP_REPO_REVISION = abc123\n", want: "abc123"},
+		{name: "suffixed name", content: "ARO_HCP_REPO_REVISION_OLD = other\nARO_HCP_REPO_REVISION = abc123\n", want: "abc123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractRevisionFromContent(tt.content, "Revision.mk", varName)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractRevisionFromContentMakefileNotFound(t *testing.T) {
+	const varName = "ARO_HCP_REPO_REVISION"
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "empty", content: ""},
+		{name: "only prefixed name", content: "MY_ARO_HCP_REPO_REVISION = other\n"},
+		{name: "only exported prefixed name", content: "export MY_ARO_HCP_REPO_REVISION = other\n"},
+		{name: "only suffixed name", content: "ARO_HCP_REPO_REVISION_OLD = other\n"},
+		{name: "commented out", content: "# ARO_HCP_REPO_REVISION = other\n"},
+		{name: "used in a value", content: "OTHER = $(ARO_HCP_REPO_REVISION)\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractRevisionFromContent(tt.content, "Revision.mk", varName)
+			if err == nil {
+				t.Fatalf("expected an error, got %q", got)
+			}
+			if !errors.Is(err, ErrRevisionNotFound) {
+				t.Errorf("error %v doesn't match ErrRevisionNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,825 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+var validFormats = map[string]bool{
+	"json":       true,
+	"jsonl":      true,
+	"env":        true,
+	"gitlab":     true,
+	"table":      true,
+	"prometheus": true,
+	"toml":       true,
+	"template":   true,
+	"diff-only":  true,
+	"csv-wide":   true,
+}
+
+// printResult writes result to --output in the selected output format and/or
+// to stdout in the stdout format
+func printResult(result map[string][]CommitInfo, meta resultMeta, redactRe *regexp.Regexp) error {
+	meta.RepoName = repoName
+	if onlyChanged {
+		result, meta = filterChangedFromBaseline(result, meta, baselineEnv)
+	}
+	if onlyNew {
+		filtered, filteredMeta, err := filterChangedSinceLastRun(result, meta)
+		if err != nil {
+			return err
+		}
+		result, meta = filtered, filteredMeta
+	}
+	if !inclWarnings {
+		meta.Warnings = nil
+	}
+
+	// Redaction only applies to what is printed, never to the values used
+	// internally such as the ones --expect-file compares
+	unredacted := result
+	if redactRe != nil {
+		result = redactRevisions(result, redactRe)
+	}
+
+	// The ages change with every run
+	if histogram && !reproducible {
+		meta.Histogram = buildAgeHistogram(result, currentTime())
+	}
+
+	if includeHash {
+		hash, err := resultHash(result)
+		if err != nil {
+			return err
+		}
+		meta.ResultHash = hash
+	}
+
+	if withChecksum {
+		checksum, err := outputChecksum(result, meta)
+		if err != nil {
+			return err
+		}
+		meta.Checksum = checksum
+		if checksumFile != "" {
+			if err := writeFileAtomic(checksumFile, []byte(checksum+"\n")); err != nil {
+				return fmt.Errorf("failed to write '%s': %v", checksumFile, err)
+			}
+		}
+	}
+
+	// Render and validate everything before writing anything, so a result
+	// failing --validate-output or --strict-json reaches neither the file nor
+	// stdout
+	if strictJSON {
+		if err := checkStrictJSON(result, meta, redactRe != nil); err != nil {
+			return err
+		}
+	}
+	var fileContent, stdoutContent string
+	if outputFile != "" {
+		content, err := renderResult(result, meta, outFormat)
+		if err != nil {
+			return err
+		}
+		if err := validateOutput(content, outFormat); err != nil {
+			return err
+		}
+		fileContent = content
+	}
+	format := stdoutFormat()
+	if format != "" {
+		content, err := renderResult(result, meta, format)
+		if err != nil {
+			return err
+		}
+		if err := validateOutput(content, format); err != nil {
+			return err
+		}
+		stdoutContent = content
+	}
+
+	if outputFile != "" {
+		if err := writeFileAtomic(outputFile, []byte(fileContent)); err != nil {
+			return fmt.Errorf("failed to write '%s': %v", outputFile, err)
+		}
+	}
+	if format != "" {
+		fmt.Print(stdoutContent)
+	}
+
+	if expectFile != "" {
+		return checkExpected(unredacted, expectFile)
+	}
+	return nil
+}
+
+// finishRun prints result and updates --state-file, exiting on failure. A
+// failed check like --expect-file still updates the state file before exiting.
+// failure is the error of a branch that couldn't be processed, which exits
+// with its category after the rest of the result is printed.
+func finishRun(result map[string][]CommitInfo, meta resultMeta, redactRe *regexp.Regexp, selectedEnvs []string, failure error) {
+	printErr := printResult(result, meta, redactRe)
+	if printErr == nil && failIfMissing {
+		printErr = checkMissingEnvironments(result, selectedEnvs)
+	}
+	if printErr != nil && !errors.Is(printErr, ErrGateFailure) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", printErr)
+		os.Exit(exitCodeForError(printErr))
+	}
+	if stateFile != "" {
+		if err := updateState(result); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitCodeForError(err))
+		}
+	}
+	if printErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", printErr)
+		os.Exit(ExitGateFailure)
+	}
+	if failure != nil {
+		os.Exit(exitCodeForError(failure))
+	}
+}
+
+// checkMissingEnvironments returns an ErrGateFailure error naming the
+// selected environments without any commit in result, e.g. because their
+// branch or revision file is missing
+func checkMissingEnvironments(result map[string][]CommitInfo, selectedEnvs []string) error {
+	var missing []string
+	for _, envName := range selectedEnvs {
+		if len(result[envName]) == 0 {
+			missing = append(missing, envName)
+		}
+	}
+	if len(missing) > 0 {
+		return markError(ErrGateFailure, fmt.Errorf("no revision found for environment(s) %s", strings.Join(missing, ", ")))
+	}
+	return nil
+}
+
+// stdoutFormat returns the format printed to stdout, or "" if nothing is
+// printed because the result only goes to --output
+func stdoutFormat() string {
+	if stdoutFmt != "" {
+		return stdoutFmt
+	}
+	if outputFile != "" {
+		return ""
+	}
+	return outFormat
+}
+
+func renderResult(result map[string][]CommitInfo, meta resultMeta, format string) (string, error) {
+	switch format {
+	case "env":
+		return formatEnv(result), nil
+	case "gitlab":
+		return formatGitLab(result)
+	case "table":
+		if meta.Histogram != nil {
+			return formatTable(result) + "\n" + formatHistogram(meta.Histogram), nil
+		}
+		return formatTable(result), nil
+	case "prometheus":
+		return formatPrometheus(result), nil
+	case "jsonl":
+		return formatJSONL(result)
+	case "toml":
+		return formatTOML(result)
+	case "template":
+		return formatTemplate(result, meta)
+	case "diff-only":
+		return formatTransitions(result)
+	case "csv-wide":
+		return formatWideCSV(result)
+	}
+
+	var output interface{} = outputEnvironments(result)
+	if withMeta {
+		output = resultWithMeta{
+			Environments: outputEnvironments(result),
+			Meta:         meta,
+			ResultHash:   meta.ResultHash,
+		}
+	}
+
+	// Keep < and > of the histogram buckets readable
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(output); err != nil {
+		return "", markError(ErrOutput, fmt.Errorf("failed to marshal JSON: %v", err))
+	}
+
+	return buf.String(), nil
+}
+
+// outputCommit is a CommitInfo as printed by the JSON based formats, with the
+// field names set by --key-revision and --key-date
+type outputCommit CommitInfo
+
+// commitExtraKeys are the JSON names of the CommitInfo fields after the
+// revision and the commit date, in field order. outputCommit emits them
+// under these names whatever --key-revision and --key-date are.
+var commitExtraKeys = func() []string {
+	commitType := reflect.TypeOf(CommitInfo{})
+	var keys []string
+	for i := 2; i < commitType.NumField(); i++ {
+		name, _, _ := strings.Cut(commitType.Field(i).Tag.Get("json"), ",")
+		keys = append(keys, name)
+	}
+	return keys
+}()
+
+func (c outputCommit) MarshalJSON() ([]byte, error) {
+	type plain CommitInfo
+	if keyRevision == "repo_revision" && keyDate == "commit_date" {
+		return json.Marshal(plain(c))
+	}
+
+	// Struct tags are static, so build the object with the configured names
+	// while keeping the field order
+	type field struct {
+		key   string
+		value interface{}
+	}
+	fields := []field{
+		{keyRevision, c.RepoRevision},
+		{keyDate, c.CommitDate},
+	}
+	commitValue := reflect.ValueOf(CommitInfo(c))
+	for i, key := range commitExtraKeys {
+		// All the other fields are omitempty
+		fieldValue := commitValue.Field(i + 2)
+		if fieldValue.IsZero() {
+			continue
+		}
+		fields = append(fields, field{key, reflect.Indirect(fieldValue).Interface()})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(field.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func outputEnvironments(result map[string][]CommitInfo) envMap[[]outputCommit] {
+	environments := make(envMap[[]outputCommit], len(result))
+	for envName, commits := range result {
+		converted := make([]outputCommit, len(commits))
+		for i, commit := range commits {
+			converted[i] = outputCommit(commit)
+		}
+		environments[envName] = converted
+	}
+	return environments
+}
+
+// validateOutputKeys rejects --key-revision and --key-date values that would
+// produce ambiguous objects
+func validateOutputKeys() error {
+	if keyRevision == "" || keyDate == "" {
+		return fmt.Errorf("--key-revision and --key-date must not be empty")
+	}
+	if keyRevision == keyDate {
+		return fmt.Errorf("--key-revision and --key-date must differ, both are '%s'", keyRevision)
+	}
+	for _, key := range []string{keyRevision, keyDate} {
+		if containsString(commitExtraKeys, key) {
+			return fmt.Errorf("output key '%s' is already used by another field", key)
+		}
+	}
+	return nil
+}
+
+// resultHash returns the hex encoded SHA-256 of result in its compact JSON
+// form. Environments are in canonical order and fields in struct order, so the
+// hash only changes when the result does.
+func resultHash(result map[string][]CommitInfo) (string, error) {
+	jsonData, err := json.Marshal(envMap[[]CommitInfo](result))
+	if err != nil {
+		return "", markError(ErrOutput, fmt.Errorf("failed to marshal JSON: %v", err))
+	}
+	sum := sha256.Sum256(jsonData)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// outputChecksum returns the hex encoded SHA-256 of the --with-meta JSON output
+// without the checksum. The output is canonicalized first, compact with every
+// object's keys sorted, so the checksum doesn't depend on formatting.
+func outputChecksum(result map[string][]CommitInfo, meta resultMeta) (string, error) {
+	meta.Checksum = ""
+	jsonData, err := json.Marshal(resultWithMeta{
+		Environments: outputEnvironments(result),
+		Meta:         meta,
+		ResultHash:   meta.ResultHash,
+	})
+	if err != nil {
+		return "", markError(ErrOutput, fmt.Errorf("failed to marshal JSON: %v", err))
+	}
+
+	// Maps are marshalled with sorted keys, which takes care of the
+	// environment order and struct field order alike
+	var canonical interface{}
+	if err := json.Unmarshal(jsonData, &canonical); err != nil {
+		return "", fmt.Errorf("failed to canonicalize JSON: %v", err)
+	}
+	// Keep < and > as they are so that other tools produce the same form
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(canonical); err != nil {
+		return "", markError(ErrOutput, fmt.Errorf("failed to marshal JSON: %v", err))
+	}
+
+	sum := sha256.Sum256(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// writeFileAtomic writes to a temporary file next to path and renames it, so
+// readers such as the node_exporter textfile collector never see a partial
+// file
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// filterChangedFromBaseline drops the environments whose tip revision is the
+// same as the one of the baseline environment, including the baseline itself
+func filterChangedFromBaseline(result map[string][]CommitInfo, meta resultMeta, baseline string) (map[string][]CommitInfo, resultMeta) {
+	baselineCommits, ok := result[baseline]
+	if !ok || len(baselineCommits) == 0 {
+		warning := Warning{
+			Category: WarningBaseline,
+			Env:      baseline,
+			Message:  fmt.Sprintf("baseline environment '%s' has no result, not filtering unchanged environments", baseline),
+		}
+		printWarnings([]Warning{warning})
+		meta.Warnings = append(meta.Warnings, warning)
+		return result, meta
+	}
+	baselineRevision := baselineCommits[0].RepoRevision
+
+	filtered := make(map[string][]CommitInfo)
+	filteredMeta := meta
+	filteredMeta.Environments = make(envMap[*envMeta])
+	for envName, commits := range result {
+		if len(commits) > 0 && commits[0].RepoRevision == baselineRevision {
+			continue
+		}
+		filtered[envName] = commits
+		if envInfo, ok := meta.Environments[envName]; ok {
+			filteredMeta.Environments[envName] = envInfo
+		}
+	}
+	return filtered, filteredMeta
+}
+
+// redactRevisions returns a copy of result where every part of a revision
+// matching re is replaced with ***
+func redactRevisions(result map[string][]CommitInfo, re *regexp.Regexp) map[string][]CommitInfo {
+	redacted := make(map[string][]CommitInfo, len(result))
+	for envName, commits := range result {
+		var redactedCommits []CommitInfo
+		for _, commit := range commits {
+			commit.RepoRevision = re.ReplaceAllString(commit.RepoRevision, "***")
+			redactedCommits = append(redactedCommits, commit)
+		}
+		redacted[envName] = redactedCommits
+	}
+	return redacted
+}
+
+// formatEnv renders the tip commit of each environment as shell-sourceable
+// KEY=value lines, e.g. REPO_REV_PROD=abc123 and REPO_REV_PROD_DATE=...
+func formatEnv(result map[string][]CommitInfo) string {
+	var sb strings.Builder
+	for _, envName := range sortedEnvNames(result) {
+		commits := result[envName]
+		if len(commits) == 0 {
+			continue
+		}
+		tip := commits[0]
+		prefix := "REPO_REV_" + shellVarName(envName)
+		fmt.Fprintf(&sb, "%s=%s\n", prefix, shellQuote(tip.RepoRevision))
+		fmt.Fprintf(&sb, "%s_DATE=%s\n", prefix, shellQuote(tip.CommitDate))
+	}
+	return sb.String()
+}
+
+// formatGitLab renders the tip commit of each environment as the KEY=value
+// lines of a GitLab CI dotenv report, e.g. REPO_REV_PROD=abc123 and
+// REPO_REV_PROD_DATE=2025-09-23T15:28:32Z. GitLab doesn't allow spaces in the
+// values, so the date is in RFC 3339 instead of the usual format, and quoting
+// isn't used since GitLab would keep the quotes.
+func formatGitLab(result map[string][]CommitInfo) (string, error) {
+	var sb strings.Builder
+	for _, envName := range sortedEnvNames(result) {
+		commits := result[envName]
+		if len(commits) == 0 {
+			continue
+		}
+		tip := commits[0]
+		if strings.ContainsAny(tip.RepoRevision, " \t\r\n") || tip.RepoRevision == "" {
+			return "", fmt.Errorf("revision '%s' of environment '%s' can't be written to a GitLab dotenv report", tip.RepoRevision, envName)
+		}
+		date, err := formatCommitDate(tip.CommitDate, time.RFC3339)
+		if err != nil {
+			return "", err
+		}
+		prefix := "REPO_REV_" + shellVarName(envName)
+		fmt.Fprintf(&sb, "%s=%s\n", prefix, tip.RepoRevision)
+		fmt.Fprintf(&sb, "%s_DATE=%s\n", prefix, date)
+	}
+	return sb.String(), nil
+}
+
+// formatTable renders result as a human readable table, one row per commit
+func formatTable(result map[string][]CommitInfo) string {
+	now := currentTime()
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENVIRONMENT\tREVISION\tCOMMIT DATE")
+	for _, envName := range sortedEnvNames(result) {
+		for i, commit := range result[envName] {
+			label := envName
+			if i > 0 {
+				// Only label the tip row, history rows follow below it
+				label = ""
+			}
+			revision := commit.RepoRevision
+			if commit.Status != "" {
+				revision += " (" + commit.Status + ")"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", label, revision, tableDate(commit.CommitDate, now))
+		}
+	}
+	w.Flush()
+	return sb.String()
+}
+
+// tableDate renders a commit date for the table, with --relative-time as e.g.
+// "3 days ago (2024-01-15 10:30:00 +0000)". Dates that can't be parsed are
+// shown as they are.
+func tableDate(dateStr string, now time.Time) string {
+	if !relativeDates || reproducible {
+		return dateStr
+	}
+	t, err := parseCommitDate(dateStr)
+	if err != nil {
+		return dateStr
+	}
+	return fmt.Sprintf("%s (%s)", relativeTime(t, now), dateStr)
+}
+
+// ageBucket is the number of environments whose tip commit age falls into a
+// bucket of the --histogram summary
+type ageBucket struct {
+	Bucket       string `json:"bucket"`
+	Environments int    `json:"environments"`
+}
+
+// ageBuckets are the upper bounds of the histogram buckets, the last bucket
+// takes everything older
+var ageBuckets = []struct {
+	name  string
+	below time.Duration
+}{
+	{"<1d", 24 * time.Hour},
+	{"1-7d", 7 * 24 * time.Hour},
+	{"7-30d", 30 * 24 * time.Hour},
+	{">30d", 0},
+}
+
+// buildAgeHistogram counts the environments of result by the age of their tip
+// commit at now. Environments without a parseable tip date aren't counted.
+func buildAgeHistogram(result map[string][]CommitInfo, now time.Time) []ageBucket {
+	histogram := make([]ageBucket, len(ageBuckets))
+	for i, b := range ageBuckets {
+		histogram[i].Bucket = b.name
+	}
+
+	for _, commits := range result {
+		if len(commits) == 0 {
+			continue
+		}
+		commitTime, err := parseCommitDate(commits[0].CommitDate)
+		if err != nil {
+			continue
+		}
+		age := now.Sub(commitTime)
+		for i, b := range ageBuckets {
+			if b.below == 0 || age < b.below {
+				histogram[i].Environments++
+				break
+			}
+		}
+	}
+	return histogram
+}
+
+func formatHistogram(histogram []ageBucket) string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIP AGE\tENVIRONMENTS")
+	for _, b := range histogram {
+		fmt.Fprintf(w, "%s\t%d\n", b.Bucket, b.Environments)
+	}
+	w.Flush()
+	return sb.String()
+}
+
+// formatJSONL renders one JSON object per environment and line, e.g.
+// {"environment":"prod","commits":[...]}
+func formatJSONL(result map[string][]CommitInfo) (string, error) {
+	var sb strings.Builder
+	environments := outputEnvironments(result)
+	for _, envName := range sortedEnvNames(result) {
+		line, err := json.Marshal(struct {
+			RepoName    string         `json:"repo_name,omitempty"`
+			Environment string         `json:"environment"`
+			Commits     []outputCommit `json:"commits"`
+		}{repoName, envName, environments[envName]})
+		if err != nil {
+			return "", markError(ErrOutput, fmt.Errorf("failed to marshal JSON: %v", err))
+		}
+		sb.Write(line)
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// revisionTransition is a commit that changed the revision value from the one
+// of the commit before it
+type revisionTransition struct {
+	Date         string `json:"date"`
+	FromRevision string `json:"from_revision"`
+	ToRevision   string `json:"to_revision"`
+}
+
+// revisionTransitions returns the transitions within commits, newest first
+// like the commits themselves. The oldest commit has nothing to compare to,
+// and commits that kept the revision, e.g. reformatting the file, are left
+// out.
+func revisionTransitions(commits []CommitInfo) []revisionTransition {
+	transitions := []revisionTransition{}
+	for i := 0; i+1 < len(commits); i++ {
+		newer, older := commits[i], commits[i+1]
+		if newer.RepoRevision == older.RepoRevision {
+			continue
+		}
+		transitions = append(transitions, revisionTransition{
+			Date:         newer.CommitDate,
+			FromRevision: older.RepoRevision,
+			ToRevision:   newer.RepoRevision,
+		})
+	}
+	return transitions
+}
+
+// formatTransitions renders the revision transitions of each environment as a
+// JSON object keyed by environment
+func formatTransitions(result map[string][]CommitInfo) (string, error) {
+	transitions := make(envMap[[]revisionTransition])
+	for envName, commits := range result {
+		transitions[envName] = revisionTransitions(commits)
+	}
+
+	jsonData, err := json.MarshalIndent(transitions, "", "  ")
+	if err != nil {
+		return "", markError(ErrOutput, fmt.Errorf("failed to marshal JSON: %v", err))
+	}
+	return string(jsonData) + "\n", nil
+}
+
+// formatWideCSV renders result as CSV with one row per commit date across all
+// environments, newest first, and one column per environment holding the
+// revision it had pinned at that date, i.e. that of its latest entry at or
+// before it. Cells before the oldest entry of an environment are empty.
+func formatWideCSV(result map[string][]CommitInfo) (string, error) {
+	envNames := sortedEnvNames(result)
+
+	// All dates are in UTC, so they sort chronologically as strings
+	commits := make(map[string][]CommitInfo)
+	seen := make(map[string]bool)
+	var dates []string
+	for _, envName := range envNames {
+		envCommits := append([]CommitInfo(nil), result[envName]...)
+		// The newest entry wins if several commits share a date
+		sort.SliceStable(envCommits, func(i, j int) bool {
+			return envCommits[i].CommitDate > envCommits[j].CommitDate
+		})
+		commits[envName] = envCommits
+		for _, commit := range envCommits {
+			if !seen[commit.CommitDate] {
+				seen[commit.CommitDate] = true
+				dates = append(dates, commit.CommitDate)
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dates)))
+
+	// next is the index of the first entry of each environment at or before
+	// the current row, which only moves forward as the rows get older
+	next := make(map[string]int)
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	w.Write(append([]string{"commit_date"}, envNames...))
+	for _, date := range dates {
+		record := []string{date}
+		for _, envName := range envNames {
+			envCommits := commits[envName]
+			i := next[envName]
+			for i < len(envCommits) && envCommits[i].CommitDate > date {
+				i++
+			}
+			next[envName] = i
+			revision := ""
+			if i < len(envCommits) {
+				revision = envCommits[i].RepoRevision
+			}
+			record = append(record, revision)
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", fmt.Errorf("failed to write CSV: %v", err)
+	}
+	return sb.String(), nil
+}
+
+// formatTOML renders result as TOML with an array of tables per environment,
+// e.g. [[prod]] followed by the fields of each commit
+func formatTOML(result map[string][]CommitInfo) (string, error) {
+	var sb strings.Builder
+	// The encoder sorts map keys alphabetically, so encode the environments
+	// one at a time to keep the canonical order
+	for i, envName := range sortedEnvNames(result) {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		env := map[string][]CommitInfo{envName: result[envName]}
+		if err := toml.NewEncoder(&sb).Encode(env); err != nil {
+			return "", markError(ErrOutput, fmt.Errorf("failed to marshal TOML: %v", err))
+		}
+	}
+	return sb.String(), nil
+}
+
+// sortedEnvNames returns the environments of result in canonical order
+func sortedEnvNames[T any](result map[string]T) []string {
+	var envNames []string
+	for envName := range result {
+		envNames = append(envNames, envName)
+	}
+	sortEnvNames(envNames)
+	return envNames
+}
+
+// sortEnvNames sorts environment names in the order of allBranches, followed
+// by any other environments alphabetically
+func sortEnvNames(envNames []string) {
+	rank := func(envName string) int {
+		for i, eb := range allBranches {
+			if eb.Env == envName {
+				return i
+			}
+		}
+		return len(allBranches)
+	}
+	sort.SliceStable(envNames, func(i, j int) bool {
+		ri, rj := rank(envNames[i]), rank(envNames[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return envNames[i] < envNames[j]
+	})
+}
+
+// envMap is a map keyed by environment name that marshals to a JSON object
+// with its keys in canonical environment order rather than alphabetically
+type envMap[T any] map[string]T
+
+func (m envMap[T]) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, envName := range sortedEnvNames(m) {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(envName)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(m[envName])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// shellVarName uppercases name and replaces anything that isn't valid in a
+// shell variable identifier with an underscore
+func shellVarName(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToUpper(name) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
+
+// shellQuote wraps value in single quotes so it survives eval unchanged
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// formatPrometheus renders the tip commit of each environment in the
+// Prometheus text exposition format, as read by the node_exporter textfile
+// collector
+func formatPrometheus(result map[string][]CommitInfo) string {
+	var sb strings.Builder
+	sb.WriteString("# HELP repo_rev_commit_timestamp_seconds Unix time of the commit that last changed the revision file.\n")
+	sb.WriteString("# TYPE repo_rev_commit_timestamp_seconds gauge\n")
+	for _, envName := range sortedEnvNames(result) {
+		commits := result[envName]
+		if len(commits) == 0 {
+			continue
+		}
+		tip := commits[0]
+		commitTime, err := parseCommitDate(tip.CommitDate)
+		if err != nil {
+			continue
+		}
+		var repoLabel string
+		if repoName != "" {
+			repoLabel = fmt.Sprintf("repo=\"%s\",", escapeLabelValue(repoName))
+		}
+		fmt.Fprintf(&sb, "repo_rev_commit_timestamp_seconds{%senvironment=\"%s\",revision=\"%s\"} %d\n",
+			repoLabel, escapeLabelValue(envName), escapeLabelValue(tip.RepoRevision), commitTime.Unix())
+	}
+	return sb.String()
+}
+
+// escapeLabelValue escapes a Prometheus label value
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestFormatWideCSV(t *testing.T) {
+	result := map[string][]CommitInfo{
+		"int": {
+			{RepoRevision: "ccccccc3", CommitDate: "2026-10-10 12:00:00 +0000"},
+			{RepoRevision: "aaaaaaa1", CommitDate: "2026-10-01 12:00:00 +0000"},
+		},
+		"prod": {
+			{RepoRevision: "bbbbbbb2", CommitDate: "2026-10-05 12:00:00 +0000"},
+			{RepoRevision: "aaaaaaa1", CommitDate: "2026-10-03 12:00:00 +0000"},
+		},
+	}
+	// Each cell carries the revision of the environment's latest entry at or
+	// before the row date, and is only empty before its oldest entry
+	want := "commit_date,int,prod\n" +
+		"2026-10-10 12:00:00 +0000,ccccccc3,bbbbbbb2\n" +
+		"2026-10-05 12:00:00 +0000,aaaaaaa1,bbbbbbb2\n" +
+		"2026-10-03 12:00:00 +0000,aaaaaaa1,aaaaaaa1\n" +
+		"2026-10-01 12:00:00 +0000,aaaaaaa1,\n"
+
+	got, err := formatWideCSV(result)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// outputSchema is the compiled --validate-output schema, nil without the flag
+var outputSchema *jsonschema.Schema
+
+// loadOutputSchema compiles the JSON Schema at schemaPath
+func loadOutputSchema(schemaPath string) (*jsonschema.Schema, error) {
+	schema, err := jsonschema.Compile(schemaPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output schema '%s': %v", schemaPath, err)
+	}
+	return schema, nil
+}
+
+// validateOutput checks content rendered in format against the
+// --validate-output schema. For jsonl every line is validated on its own, the
+// other formats aren't JSON and are left alone.
+func validateOutput(content, format string) error {
+	if outputSchema == nil {
+		return nil
+	}
+
+	var documents []string
+	switch format {
+	case "json":
+		documents = []string{content}
+	case "jsonl":
+		documents = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	default:
+		return nil
+	}
+
+	for i, document := range documents {
+		if document == "" {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(document), &value); err != nil {
+			return fmt.Errorf("failed to parse %s output: %v", format, err)
+		}
+		if err := outputSchema.Validate(value); err != nil {
+			if format == "jsonl" {
+				return fmt.Errorf("line %d of the jsonl output doesn't match the output schema: %v", i+1, err)
+			}
+			return fmt.Errorf("json output doesn't match the output schema: %v", err)
+		}
+	}
+	return nil
+}
+
+// strictRevision is the character set --strict-json allows in revisions:
+// commit hashes, tags and branch names, but nothing git checkout would take
+// as an option. strictRedactedRevision also allows the *** of redaction.
+var (
+	strictRevision         = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/+-]*$`)
+	strictRedactedRevision = regexp.MustCompile(`^[A-Za-z0-9*][A-Za-z0-9._/+*-]*$`)
+)
+
+// checkStrictJSON renders result as JSON, parses it back and checks every
+// revision against strictRevision, returning an error naming the first
+// malformed one. Like with --validate-output the run fails without printing
+// or storing anything. The JSON is checked whatever the output formats are,
+// since it is the canonical form of the result.
+func checkStrictJSON(result map[string][]CommitInfo, meta resultMeta, redacted bool) error {
+	content, err := renderResult(result, meta, "json")
+	if err != nil {
+		return err
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(content), &doc); err != nil {
+		return fmt.Errorf("failed to parse the json output: %v", err)
+	}
+	if withMeta {
+		var wrapped map[string]json.RawMessage
+		if err := json.Unmarshal(doc["environments"], &wrapped); err != nil {
+			return fmt.Errorf("failed to parse the environments of the json output: %v", err)
+		}
+		doc = wrapped
+	}
+
+	allowed := strictRevision
+	if redacted {
+		allowed = strictRedactedRevision
+	}
+	for _, envName := range sortedEnvNames(doc) {
+		var commits []map[string]interface{}
+		if err := json.Unmarshal(doc[envName], &commits); err != nil {
+			return fmt.Errorf("failed to parse the commits of environment '%s' in the json output: %v", envName, err)
+		}
+		for i, commit := range commits {
+			revision, ok := commit[keyRevision].(string)
+			if !ok {
+				return fmt.Errorf("entry %d of environment '%s' has no %s string in the json output", i, envName, keyRevision)
+			}
+			if !allowed.MatchString(revision) {
+				return fmt.Errorf("malformed revision %q in entry %d of environment '%s', revisions have to start with a letter or digit followed by letters, digits and . _ / + -", revision, i, envName)
+			}
+		}
+	}
+	return nil
+}
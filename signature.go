@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// commitSignature is the GPG signature status of a commit as reported by
+// git's %G? and %GS placeholders
+type commitSignature struct {
+	// Verified is only true for a good signature from a trusted key (%G?
+	// is G). Unsigned commits and bad, expired or untrusted signatures
+	// aren't verified.
+	Verified bool
+	// Signer is the name on the signature, empty for unsigned commits
+	Signer string
+}
+
+// signatureLogFormat is appended to git log formats with --verify-signature
+const signatureLogFormat = "%G?|%GS"
+
+func parseSignature(status, signer string) commitSignature {
+	sig := commitSignature{Verified: status == "G"}
+	if status != "N" {
+		sig.Signer = signer
+	}
+	return sig
+}
+
+// lastCommitSignature returns the signature of the last commit on ref that
+// changed filePath
+func lastCommitSignature(ref, filePath string) (commitSignature, error) {
+	output, err := runGit("log", "-1", "--format="+signatureLogFormat, ref, "--", filePath)
+	if err != nil {
+		return commitSignature{}, fmt.Errorf("failed to check the signature of the last change to '%s': %w", filePath, err)
+	}
+	status, signer, _ := strings.Cut(strings.TrimSpace(string(output)), "|")
+	return parseSignature(status, signer), nil
+}
+
+// setSignature sets the signature fields of c
+func (c *CommitInfo) setSignature(sig commitSignature) {
+	verified := sig.Verified
+	c.SignatureVerified = &verified
+	c.Signer = sig.Signer
+}
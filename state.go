@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+)
+
+// loadState reads the tip revision per environment stored by the previous
+// run. ok is false if there is no state file yet.
+func loadState(path string) (state map[string]string, ok bool, err error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read state file '%s': %w", path, err)
+	}
+
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, false, fmt.Errorf("failed to parse state file '%s': %v", path, err)
+	}
+	return state, true, nil
+}
+
+func saveState(path string, state map[string]string) error {
+	jsonData, err := json.MarshalIndent(envMap[string](state), "", "  ")
+	if err != nil {
+		return markError(ErrOutput, fmt.Errorf("failed to marshal state: %v", err))
+	}
+	if err := writeFileAtomic(path, append(jsonData, '\n')); err != nil {
+		return fmt.Errorf("failed to write state file '%s': %v", path, err)
+	}
+	return nil
+}
+
+// updateState compares the tip revisions of result with the ones stored in
+// --state-file, reports the environments that changed on stderr, runs
+// --on-change-cmd for each of them and stores the new tip revisions.
+// Environments missing from result keep their stored revision, so a failed
+// branch isn't reported as a change next time.
+func updateState(result map[string][]CommitInfo) error {
+	previous, ok, err := loadState(stateFile)
+	if err != nil {
+		return err
+	}
+
+	state := make(map[string]string)
+	for envName, revision := range previous {
+		state[envName] = revision
+	}
+
+	d := snapshotDiff{}
+	for _, envName := range sortedEnvNames(result) {
+		newRevision := tipRevision(result[envName])
+		if newRevision == "" {
+			continue
+		}
+		oldRevision, known := previous[envName]
+		state[envName] = newRevision
+
+		// Without a state file there is nothing to compare against, so the
+		// first run is quiet unless every environment should count as new
+		if !ok && !firstRunChanged {
+			continue
+		}
+		if !known {
+			d.Added = append(d.Added, envName)
+		} else if oldRevision != newRevision {
+			d.Changed = append(d.Changed, envChange{
+				Environment: envName,
+				OldRevision: oldRevision,
+				NewRevision: newRevision,
+			})
+		} else {
+			continue
+		}
+
+		if onChangeCmd != "" {
+			if err := runOnChangeCmd(envName, oldRevision, newRevision); err != nil {
+				fmt.Fprintf(os.Stderr, "Error running --on-change-cmd for environment '%s': %v\n", envName, err)
+			}
+		}
+	}
+
+	if len(d.Changed) > 0 || len(d.Added) > 0 {
+		fmt.Fprint(os.Stderr, formatSnapshotDiff(d))
+	}
+
+	return saveState(stateFile, state)
+}
+
+// filterChangedSinceLastRun removes the environments whose tip revision is the
+// one stored in --state-file. Environments missing from the state file count
+// as changed, and without a state file nothing is removed. It must run before
+// updateState overwrites the state file.
+func filterChangedSinceLastRun(result map[string][]CommitInfo, meta resultMeta) (map[string][]CommitInfo, resultMeta, error) {
+	previous, ok, err := loadState(stateFile)
+	if err != nil || !ok {
+		return result, meta, err
+	}
+
+	filtered := make(map[string][]CommitInfo)
+	filteredMeta := meta
+	filteredMeta.Environments = make(envMap[*envMeta])
+	for envName, commits := range result {
+		if oldRevision, known := previous[envName]; known && oldRevision == tipRevision(commits) {
+			continue
+		}
+		filtered[envName] = commits
+		if envInfo, ok := meta.Environments[envName]; ok {
+			filteredMeta.Environments[envName] = envInfo
+		}
+	}
+	return filtered, filteredMeta, nil
+}
+
+// runOnChangeCmd runs --on-change-cmd through the shell with the change in
+// RRC_* environment variables. Its output goes to stderr so it doesn't mix
+// with the result on stdout.
+func runOnChangeCmd(envName, oldRevision, newRevision string) error {
+	cmd := exec.CommandContext(runCtx, "sh", "-c", onChangeCmd)
+	cmd.Env = append(os.Environ(),
+		"RRC_ENV="+envName,
+		"RRC_OLD_REVISION="+oldRevision,
+		"RRC_NEW_REVISION="+newRevision,
+		"RRC_REPO_NAME="+repoName,
+	)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
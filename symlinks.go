@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxSymlinkDepth bounds the symlinks followed in a row, so a link cycle in
+// the tree fails instead of looping forever
+const maxSymlinkDepth = 8
+
+// resolveSymlinkAtRef is resolveSymlinkedFile for the version of filePath in
+// the tree of ref: as long as the path is a symlink (mode 120000) there, it is
+// replaced by the link target. The result is relative to the current
+// directory like filePath, which is returned unchanged if it isn't a symlink
+// at ref or doesn't exist.
+func resolveSymlinkAtRef(ref, filePath string) (string, error) {
+	output, err := runGit("rev-parse", "--show-prefix")
+	if err != nil {
+		return "", fmt.Errorf("failed to determine the repository root: %w", err)
+	}
+	prefix := strings.TrimSpace(string(output))
+	// Tree paths are relative to the repository root
+	fullPath := path.Join(prefix, filePath)
+
+	resolved := false
+	for depth := 0; ; depth++ {
+		output, err := runGit("ls-tree", "--full-tree", ref, "--", fullPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to look up '%s' at %s: %w", filePath, ref, err)
+		}
+		// <mode> SP <type> SP <hash> TAB <path>
+		fields := strings.Fields(string(output))
+		if len(fields) < 3 || fields[0] != "120000" {
+			break
+		}
+		if depth == maxSymlinkDepth {
+			return "", fmt.Errorf("too many levels of symlinks at '%s' at %s", filePath, ref)
+		}
+
+		target, err := runGit("cat-file", "blob", fields[2])
+		if err != nil {
+			return "", fmt.Errorf("failed to read the symlink '%s' at %s: %w", fullPath, ref, err)
+		}
+		linkTarget := string(target)
+		if path.IsAbs(linkTarget) {
+			return "", fmt.Errorf("symlink '%s' at %s points to '%s' outside of the repository", fullPath, ref, linkTarget)
+		}
+		fullPath = path.Join(path.Dir(fullPath), linkTarget)
+		if fullPath == ".." || strings.HasPrefix(fullPath, "../") {
+			return "", fmt.Errorf("symlink at %s points to '%s' outside of the repository", ref, linkTarget)
+		}
+		resolved = true
+	}
+
+	if !resolved {
+		return filePath, nil
+	}
+	rel, err := filepath.Rel(path.Join("/", prefix), path.Join("/", fullPath))
+	if err != nil {
+		return "", err
+	}
+	// Keep the style of filePath, bare repositories reject ./ paths
+	if strings.HasPrefix(filePath, "./") {
+		return "./" + filepath.ToSlash(rel), nil
+	}
+	return filepath.ToSlash(rel), nil
+}
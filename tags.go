@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// getTagHistory returns the revision at each tag matching pattern, highest
+// version first, as the history of the environment pinned to tag current.
+// Tags sorting after current are newer releases and left out, as is current
+// itself unless includeCurrent is set since it's already the tip entry.
+func getTagHistory(pattern, current string, source revisionSource, includeCurrent bool) ([]CommitInfo, []SkippedCommit, error) {
+	output, err := runGit("tag", "--list", "--sort=-v:refname", pattern)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list tags matching '%s': %w", pattern, err)
+	}
+	tags := strings.Fields(string(output))
+
+	// A current tag that doesn't match the pattern can't be placed between
+	// the others, so all of them are used
+	for i, tag := range tags {
+		if tag == current {
+			if includeCurrent {
+				tags = tags[i:]
+			} else {
+				tags = tags[i+1:]
+			}
+			break
+		}
+	}
+
+	var commits []CommitInfo
+	var skipped []SkippedCommit
+	for _, tag := range tags {
+		ref := "refs/tags/" + tag
+		dateOutput, err := runGit("log", "-1", "--format=%cI", ref, "--", source.FilePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get commit date for '%s' at tag '%s': %w", source.FilePath, tag, err)
+		}
+		commitDate := strings.TrimSpace(string(dateOutput))
+
+		revision, err := extractRevisionAtCommit(ref, source)
+		if err != nil || commitDate == "" {
+			reason := fmt.Sprintf("'%s' doesn't exist at tag '%s'", source.FilePath, tag)
+			if err != nil {
+				reason = err.Error()
+			}
+			skipped = append(skipped, SkippedCommit{
+				CommitHash: tag,
+				CommitDate: commitDate,
+				Reason:     reason,
+			})
+			continue
+		}
+
+		commits = append(commits, CommitInfo{
+			RepoRevision: revision,
+			CommitDate:   commitDate,
+			Tag:          tag,
+		})
+	}
+	return commits, skipped, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// compareTargetRevisions counts in --target-repo, the repository the revisions
+// point into, how many commits the tip revision of every environment is ahead
+// of and behind the tip revision of the baseline environment, and records the
+// counts in meta. Revisions the target repository doesn't have are reported
+// as warnings but don't fail the run. The warnings leave out the revisions,
+// which may be redacted in the output.
+func compareTargetRevisions(result map[string][]CommitInfo, meta resultMeta) []Warning {
+	baseline := tipRevision(result[baselineEnv])
+	if baseline == "" || !isTargetCommit(baseline) {
+		return []Warning{{
+			Category: WarningTargetRepo,
+			Env:      baselineEnv,
+			Message:  fmt.Sprintf("the revision of baseline environment '%s' isn't a commit in '%s', nothing to compare with", baselineEnv, targetRepo),
+		}}
+	}
+
+	var warnings []Warning
+	for _, envName := range sortedEnvNames(result) {
+		revision := tipRevision(result[envName])
+		if envName == baselineEnv || revision == "" {
+			continue
+		}
+
+		if !isTargetCommit(revision) {
+			warnings = append(warnings, Warning{
+				Category: WarningTargetRepo,
+				Env:      envName,
+				Message:  fmt.Sprintf("the revision of environment '%s' isn't a commit in '%s', it may need to be fetched", envName, targetRepo),
+			})
+			continue
+		}
+		ahead, behind, err := countTargetCommits(baseline, revision)
+		if err != nil {
+			warnings = append(warnings, Warning{
+				Category: WarningTargetRepo,
+				Env:      envName,
+				Message:  fmt.Sprintf("failed to compare the revision of environment '%s' with '%s' in '%s': %v", envName, baselineEnv, targetRepo, err),
+			})
+			continue
+		}
+
+		envInfo, ok := meta.Environments[envName]
+		if !ok {
+			envInfo = &envMeta{}
+			meta.Environments[envName] = envInfo
+		}
+		envInfo.TargetAhead = &ahead
+		envInfo.TargetBehind = &behind
+	}
+	return warnings
+}
+
+// isTargetCommit tells whether revision is a commit in --target-repo
+func isTargetCommit(revision string) bool {
+	_, err := runGit("-C", targetRepo, "rev-parse", "--verify", "--quiet", revision+"^{commit}")
+	return err == nil
+}
+
+// countTargetCommits returns the number of commits in --target-repo that are
+// reachable from revision but not from base (ahead) and the other way around
+// (behind), with a single git rev-list
+func countTargetCommits(base, revision string) (ahead int, behind int, err error) {
+	output, err := runGit("-C", targetRepo, "rev-list", "--left-right", "--count", base+"..."+revision)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// <left only> TAB <right only>
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected git rev-list output '%s'", strings.TrimSpace(string(output)))
+	}
+	if behind, err = strconv.Atoi(fields[0]); err != nil {
+		return 0, 0, fmt.Errorf("unexpected git rev-list output '%s'", strings.TrimSpace(string(output)))
+	}
+	if ahead, err = strconv.Atoi(fields[1]); err != nil {
+		return 0, 0, fmt.Errorf("unexpected git rev-list output '%s'", strings.TrimSpace(string(output)))
+	}
+	return ahead, behind, nil
+}
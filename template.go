@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// outputTemplate is parsed from --template or --template-file for the
+// template format
+var outputTemplate *template.Template
+
+// templateEnv is one environment in the template data
+type templateEnv struct {
+	Name    string
+	Commits []CommitInfo
+}
+
+// templateData is what the template format renders. Environments are in
+// canonical order.
+type templateData struct {
+	Environments []templateEnv
+	Meta         resultMeta
+}
+
+var templateFuncs = template.FuncMap{
+	// date reformats a commit date with a Go time layout
+	"date": func(layout, commitDate string) (string, error) {
+		return formatCommitDate(commitDate, layout)
+	},
+	// age returns the time since a commit date, rounded to minutes. It's
+	// always 0 with --reproducible.
+	"age": func(commitDate string) (time.Duration, error) {
+		commitTime, err := parseCommitDate(commitDate)
+		if err != nil || reproducible {
+			return 0, err
+		}
+		return currentTime().Sub(commitTime).Round(time.Minute), nil
+	},
+	// ageDays returns the number of full days since a commit date. It's
+	// always 0 with --reproducible.
+	"ageDays": func(commitDate string) (int, error) {
+		commitTime, err := parseCommitDate(commitDate)
+		if err != nil || reproducible {
+			return 0, err
+		}
+		return int(currentTime().Sub(commitTime).Hours() / 24), nil
+	},
+}
+
+// loadOutputTemplate parses the template given with --template or
+// --template-file
+func loadOutputTemplate(text, file string) (*template.Template, error) {
+	if text != "" && file != "" {
+		return nil, fmt.Errorf("--template and --template-file can't be used together")
+	}
+	if file != "" {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template file '%s': %v", file, err)
+		}
+		text = string(content)
+	}
+	if text == "" {
+		return nil, fmt.Errorf("the template format requires --template or --template-file")
+	}
+
+	tmpl, err := template.New("output").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+	return tmpl, nil
+}
+
+func formatTemplate(result map[string][]CommitInfo, meta resultMeta) (string, error) {
+	data := templateData{Meta: meta}
+	for _, envName := range sortedEnvNames(result) {
+		data.Environments = append(data.Environments, templateEnv{
+			Name:    envName,
+			Commits: result[envName],
+		})
+	}
+
+	var sb strings.Builder
+	if err := outputTemplate.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("failed to render template: %v", err)
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// stdinType selects the parser for validate - since there is no file extension
+var stdinType string
+
+var validateCmd = &cobra.Command{
+	Use:   "validate <file>",
+	Short: "Check that a revision file contains a well-formed revision",
+	Long: `Extracts the revision from the given file without touching git and checks that it looks like
+a git commit hash. Prints the extracted value and exits non-zero if the file doesn't parse.
+Use - as the file to read from stdin.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runValidate,
+}
+
+func init() {
+	validateCmd.Flags().StringVar(&stdinType, "stdin-type", "mk", "Parser used when reading from stdin (mk, yaml, json)")
+	validateCmd.Flags().StringVar(&varName, "var-name", "ARO_HCP_REPO_REVISION", "Variable (Makefile) or key (YAML/JSON, dot-separated for nested keys) holding the revision")
+	validateCmd.Flags().BoolVar(&caseInsensitive, "case-insensitive", false, "Match the Makefile variable of --var-name regardless of case")
+	rootCmd.AddCommand(validateCmd)
+}
+
+// revisionFormat matches abbreviated and full git commit hashes
+var revisionFormat = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+func validateRevision(revision string) error {
+	if !revisionFormat.MatchString(revision) {
+		return fmt.Errorf("revision '%s' is not a git commit hash", revision)
+	}
+	return nil
+}
+
+// revisionMatches reports whether two revisions name the same commit. Either
+// side may be an abbreviated hash, so two hashes match if one is a prefix of
+// the other. Anything else, such as a tag or an empty value, has to be equal.
+func revisionMatches(a, b string) bool {
+	if revisionFormat.MatchString(a) && revisionFormat.MatchString(b) {
+		return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+	}
+	return a == b
+}
+
+func runValidate(cmd *cobra.Command, args []string) {
+	filePath := args[0]
+
+	switch stdinType {
+	case "mk", "yaml", "json":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: invalid --stdin-type '%s'. Valid types are: mk, yaml, json\n", stdinType)
+		os.Exit(ExitUsage)
+	}
+
+	var revision string
+	var err error
+	if filePath == "-" {
+		revision, err = extractRevisionFromStdin()
+		filePath = "stdin"
+	} else {
+		revision, err = extractRevision(filePath, varName)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitExtractionFailure)
+	}
+
+	if err := validateRevision(revision); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v in '%s'\n", err, filePath)
+		os.Exit(ExitExtractionFailure)
+	}
+
+	fmt.Println(revision)
+}
+
+// extractRevisionFromStdin extracts the revision from stdin, using the parser
+// selected with --stdin-type
+func extractRevisionFromStdin() (string, error) {
+	content, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("failed to read stdin: %v", err)
+	}
+	return extractRevisionFromContent(string(content), "stdin."+stdinType, varName)
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestRevisionMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{name: "equal hashes", a: "abc1234", b: "abc1234", want: true},
+		{name: "abbreviated and full hash", a: "abc1234", b: "abc1234def5678abc1234def5678abc1234def56", want: true},
+		{name: "full and abbreviated hash", a: "abc1234def5678abc1234def5678abc1234def56", b: "abc1234", want: true},
+		{name: "different hashes", a: "abc1234", b: "abc1235", want: false},
+		{name: "hash shorter than 7 characters", a: "abc12", b: "abc1234", want: false},
+		{name: "empty value", a: "", b: "abc1234", want: false},
+		{name: "both empty", a: "", b: "", want: true},
+		{name: "version prefix", a: "v4.16", b: "v4.16.2", want: false},
+		{name: "equal versions", a: "v4.16.2", b: "v4.16.2", want: true},
+		{name: "uppercase hash", a: "ABC1234", b: "abc1234def5678abc1234def5678abc1234def56", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := revisionMatches(tt.a, tt.b); got != tt.want {
+				t.Errorf("revisionMatches(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	waitEnv      string
+	waitRevision string
+	waitTimeout  time.Duration
+	pollInterval time.Duration
+)
+
+var waitCmd = &cobra.Command{
+	Use:   "wait <directory>",
+	Short: "Wait until an environment's tip revision reaches a given value",
+	Long: `Repeatedly fetches and reads the branch of an environment until its tip revision equals
+--revision, e.g. to block a deploy pipeline until prod pins a release. Abbreviated hashes
+of at least 7 characters match their full form, any other value has to be equal. Exits with code 0 once the revision is reached and with code 4 if
+--timeout elapses first.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runWait,
+}
+
+func init() {
+	waitCmd.Flags().StringVar(&waitEnv, "env", "", "Environment to wait for (int, stg, prod)")
+	waitCmd.Flags().StringVar(&waitRevision, "revision", "", "Revision the environment's tip has to reach")
+	waitCmd.Flags().DurationVar(&waitTimeout, "timeout", 0, "Give up after this long, e.g. 30m. 0 means wait forever.")
+	waitCmd.Flags().DurationVar(&pollInterval, "poll-interval", 30*time.Second, "Time between two checks")
+	waitCmd.Flags().BoolVar(&allowFastPoll, "allow-fast-polling", false, fmt.Sprintf("Allow --poll-interval below %s", minWatchInterval))
+	waitCmd.Flags().BoolVarP(&quickMode, "quick", "q", false, "Don't fetch, only re-read the local branch on every check")
+	waitCmd.Flags().StringVar(&revFile, "revision-file", "./hcp/Revision.mk", "Path of the revision file inside the repository")
+	waitCmd.Flags().StringVar(&varName, "var-name", "ARO_HCP_REPO_REVISION", "Variable (Makefile) or key (YAML/JSON, dot-separated for nested keys) holding the revision")
+	waitCmd.Flags().StringVar(&remoteName, "remote", "origin", "Remote the branch is fetched from")
+	waitCmd.MarkFlagRequired("env")
+	waitCmd.MarkFlagRequired("revision")
+	rootCmd.AddCommand(waitCmd)
+}
+
+func runWait(cmd *cobra.Command, args []string) {
+	selectedEnvs, err := parseEnvironments(waitEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+	if len(selectedEnvs) != 1 {
+		fmt.Fprintf(os.Stderr, "Error: --env takes a single environment\n")
+		os.Exit(ExitUsage)
+	}
+	envName := selectedEnvs[0]
+
+	waitRevision = strings.TrimSpace(waitRevision)
+	if waitRevision == "" {
+		fmt.Fprintf(os.Stderr, "Error: --revision must not be empty\n")
+		os.Exit(ExitUsage)
+	}
+	if waitTimeout < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --timeout must not be negative, got %s\n", waitTimeout)
+		os.Exit(ExitUsage)
+	}
+	if pollInterval <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: --poll-interval must be positive, got %s\n", pollInterval)
+		os.Exit(ExitUsage)
+	}
+	if pollInterval < minWatchInterval && !allowFastPoll {
+		fmt.Fprintf(os.Stderr, "Error: --poll-interval %s is below the minimum of %s, use --allow-fast-polling to allow it\n", pollInterval, minWatchInterval)
+		os.Exit(ExitUsage)
+	}
+
+	directory, err := filepath.Abs(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid directory '%s': %v\n", args[0], err)
+		os.Exit(ExitUsage)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	runCtx = ctx
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	var deadline time.Time
+	var timeoutC <-chan time.Time
+	if waitTimeout > 0 {
+		deadline = time.Now().Add(waitTimeout)
+		timer := time.NewTimer(waitTimeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+
+	for {
+		// A fetch that hangs must not outlast --timeout, so the git commands
+		// of each check are cancelled at the deadline
+		var pollCtx context.Context
+		var cancel context.CancelFunc
+		if deadline.IsZero() {
+			pollCtx, cancel = context.WithCancel(ctx)
+		} else {
+			pollCtx, cancel = context.WithDeadline(ctx, deadline)
+		}
+		runCtx = pollCtx
+		result, _, err := collectResultsInDirectory(directory, selectedEnvs)
+		timedOut := errors.Is(pollCtx.Err(), context.DeadlineExceeded)
+		cancel()
+		runCtx = ctx
+		if ctx.Err() != nil {
+			fmt.Fprintf(os.Stderr, "Interrupted while waiting for '%s'\n", envName)
+			os.Exit(ExitInterrupted)
+		}
+		if err != nil && !timedOut {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitCodeForError(err))
+		}
+
+		// A failed check is reported by collectResults and retried
+		if current := tipRevision(result[envName]); current != "" {
+			if revisionMatches(current, waitRevision) {
+				fmt.Fprintf(os.Stderr, "Environment '%s' reached revision '%s'\n", envName, current)
+				return
+			}
+			fmt.Fprintf(os.Stderr, "Environment '%s' is at revision '%s', waiting for '%s'\n", envName, current, waitRevision)
+		}
+		if timedOut {
+			fmt.Fprintf(os.Stderr, "Error: environment '%s' didn't reach revision '%s' within %s\n", envName, waitRevision, waitTimeout)
+			os.Exit(ExitGateFailure)
+		}
+
+		select {
+		case <-ctx.Done():
+			fmt.Fprintf(os.Stderr, "Interrupted while waiting for '%s'\n", envName)
+			os.Exit(ExitInterrupted)
+		case <-timeoutC:
+			fmt.Fprintf(os.Stderr, "Error: environment '%s' didn't reach revision '%s' within %s\n", envName, waitRevision, waitTimeout)
+			os.Exit(ExitGateFailure)
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Warning categories. The first ones are returned by processBranch, the
+// others are added by collectResults and printResult.
+const (
+	// WarningStale means the local branch differs from its remote-tracking
+	// branch in quick mode
+	WarningStale = "stale"
+	// WarningUnpushed means the last change to the revision file isn't on
+	// the remote-tracking branch in quick mode
+	WarningUnpushed = "unpushed"
+	// WarningLocalBranch means the remote has no such branch and the local
+	// branch was read as-is, with --allow-local
+	WarningLocalBranch = "local_branch"
+	// WarningCommitsBehind means the commits behind HEAD couldn't be counted
+	WarningCommitsBehind = "commits_behind"
+
+	// WarningBranchFailed means the branch couldn't be processed at all and
+	// the environment is missing from the result
+	WarningBranchFailed = "branch_failed"
+	// WarningSkippedCommit means the revision of a history commit couldn't
+	// be read and the commit was left out
+	WarningSkippedCommit = "skipped_commit"
+	// WarningDateParse means the date of a commit couldn't be parsed and the
+	// commit was left out
+	WarningDateParse = "date_parse"
+	// WarningTargetRepo means a revision couldn't be compared with the
+	// baseline environment's in --target-repo
+	WarningTargetRepo = "target_repo"
+	// WarningWorktree means the revision in the working tree couldn't be
+	// read with --include-worktree
+	WarningWorktree = "worktree"
+	// WarningBaseline means the baseline environment has no result, so
+	// --only-changed didn't filter anything
+	WarningBaseline = "baseline"
+)
+
+// Warning is a problem that didn't stop a branch or the run from being
+// processed. It is returned to the caller instead of being printed, so it can
+// be reported in whatever way fits, and is added to the meta output with
+// --include-warnings.
+type Warning struct {
+	Category string `json:"category"`
+	Message  string `json:"message"`
+	// Env is the environment the warning is about, set by collectResults
+	Env string `json:"environment,omitempty"`
+	// Commit is the hash of the commit the warning is about, if any
+	Commit string `json:"commit,omitempty"`
+}
+
+// printWarnings reports the warnings of a branch on stderr. The warnings
+// about a local branch that may not match the remote are left out with
+// --no-stale-warning.
+func printWarnings(warnings []Warning) {
+	for _, w := range warnings {
+		switch w.Category {
+		case WarningStale, WarningUnpushed, WarningLocalBranch:
+			if noStale {
+				continue
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w.Message)
+	}
+}
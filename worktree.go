@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// CommitStatusUncommitted marks the entry of --include-worktree, which holds
+// the revision in the working tree rather than in a commit
+const CommitStatusUncommitted = "uncommitted"
+
+// worktreeFile is the revision file in the working tree of the checked out
+// branch, read for --include-worktree
+type worktreeFile struct {
+	Branch  string
+	Path    string
+	Content string
+	ModTime time.Time
+}
+
+// readWorktreeFile reads filePath from the working tree. It returns nil if
+// HEAD is detached, since then no environment's branch is checked out.
+func readWorktreeFile(filePath string) (*worktreeFile, error) {
+	output, err := runGit("symbolic-ref", "--quiet", "--short", "HEAD")
+	if err != nil {
+		return nil, nil
+	}
+	branch := strings.TrimSpace(string(output))
+
+	if !noFollowLinks {
+		filePath, err = resolveSymlinkedFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve '%s' in the working tree: %w", filePath, err)
+		}
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read '%s' in the working tree: %v", filePath, err)
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read '%s' in the working tree: %v", filePath, err)
+	}
+	return &worktreeFile{
+		Branch:  branch,
+		Path:    filePath,
+		Content: string(content),
+		ModTime: info.ModTime(),
+	}, nil
+}
+
+// uncommittedEntry returns the entry for the revision in the working tree, or
+// nil if it is the same as the committed revision. The file's modification
+// time stands in for the commit date.
+func (f *worktreeFile) uncommittedEntry(varName, committed string) (*CommitInfo, error) {
+	revision, err := extractRevisionFromContent(f.Content, f.Path, varName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract the uncommitted revision from '%s': %w", f.Path, err)
+	}
+	if revision == committed {
+		return nil, nil
+	}
+	return &CommitInfo{
+		RepoRevision: revision,
+		CommitDate:   f.ModTime.Format(time.RFC3339),
+		Status:       CommitStatusUncommitted,
+	}, nil
+}